@@ -1,24 +1,67 @@
 package app
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/sirprodigle/linkpatrol/internal/baseline"
 	"github.com/sirprodigle/linkpatrol/internal/cache"
 	"github.com/sirprodigle/linkpatrol/internal/config"
 	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/metrics"
+	"github.com/sirprodigle/linkpatrol/internal/profiles"
+	linkreport "github.com/sirprodigle/linkpatrol/internal/report"
+	"github.com/sirprodigle/linkpatrol/internal/sourcemap"
+	"github.com/sirprodigle/linkpatrol/internal/suppress"
 	"github.com/sirprodigle/linkpatrol/internal/walker"
+	"github.com/sirprodigle/linkpatrol/internal/webhook"
 	"github.com/sirprodigle/linkpatrol/internal/workers"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
 )
 
+// ErrInterrupted is returned by Run when the crawl was stopped by a signal
+// before it finished, so the reported results are partial.
+var ErrInterrupted = linkpatrol.ErrInterrupted
+
+// App runs a link check configured from the command line and prints the results
 type App struct {
-	config     *config.Config
-	cache      *cache.ResultsCache
-	workerPool *workers.WorkerPool
-	logger     *logger.Logger
+	config  *config.Config
+	client  *http.Client
+	options linkpatrol.Options
+	checker *linkpatrol.Checker
+	logger  *logger.Logger
+	metrics *metrics.Metrics // nil unless metrics are served
+	// output gets the progress and webhook dry runs: standard error when
+	// results stream to standard output
+	output io.Writer
+
+	webhooks []*webhook.Hook
+	sources  *sourcemap.Map // where failing links were written, for reports
+
+	// ndjson streams results as they're recorded; ndjsonFile is closed once the run is over
+	ndjson     *linkreport.NDJSON
+	ndjsonFile *os.File
+
+	// baseline holds the known failures that don't fail the run, if a baseline file exists
+	baseline *baseline.Baseline
+
+	// expiredSuppressions no longer apply and are warned about so they get cleaned up
+	expiredSuppressions []suppress.Rule
+	suppressedMu        sync.Mutex
+	suppressed          map[string]bool // URLs of failures muted by a suppression
 }
 
-func New(cfg *config.Config) *App {
+func New(cfg *config.Config) (*App, error) {
 	var loggerOpts []logger.Option
 	if cfg.TermWidth > 0 {
 		loggerOpts = append(loggerOpts, logger.WithTerminalWidth(cfg.TermWidth))
@@ -26,69 +69,461 @@ func New(cfg *config.Config) *App {
 	if cfg.NoTruncate {
 		loggerOpts = append(loggerOpts, logger.WithNoTruncate(cfg.NoTruncate))
 	}
-
-	// Generate results Channel early so that the worker pool and cache can use it
-	resultsChan := make(chan cache.CacheEntry, 100)
-	toWalkChan := make(chan walker.WalkerRequest, 10000)
-	toTestChan := make(chan walker.WalkerRequest, 100)
+	// Workflow commands are only picked up from standard output, so they'd be mixed into the stream
+	if cfg.NDJSON == "-" && cfg.GitHubAnnotations {
+		return nil, errors.New("--github-annotations and --ndjson - both write to standard output; stream the results to a file instead")
+	}
+	// Standard output is left to the stream, so it can be piped into jq
+	output := os.Stdout
+	if cfg.NDJSON == "-" {
+		output = os.Stderr
+		loggerOpts = append(loggerOpts, logger.WithOutput(output))
+	}
 	log := logger.New(cfg.Verbose, loggerOpts...)
-	cacheInstance := cache.NewResultsCache(resultsChan)
-	workerPool := workers.NewWorkerPool(
-		cacheInstance,
-		cfg.Concurrency,
-		cfg.Timeout,
-		cfg.Rate,
-		resultsChan,
-		toWalkChan,
-		toTestChan,
-		log,
-		cfg.Target,
-	)
-
-	return &App{
-		config:     cfg,
-		cache:      cacheInstance,
-		workerPool: workerPool,
-		logger:     log,
+
+	options, expired, err := NewOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	options.Output = output
+	options.ShowStats = true
+	options.TerminalWidth = log.GetTerminalWidth()
+
+	app := &App{
+		config:  cfg,
+		client:  options.Client,
+		options: options,
+		logger:  log,
+		output:  output,
+	}
+	if app.webhooks, err = webhook.New(cfg.Webhooks); err != nil {
+		return nil, err
+	}
+	app.sources = sourcemap.New(cfg.Sources)
+	if cfg.NDJSON != "" {
+		if err := app.streamResults(cfg.NDJSON); err != nil {
+			return nil, err
+		}
 	}
+	if cfg.MetricsAddr != "" {
+		app.metrics = metrics.New(cfg.MetricsPerHost)
+		app.metrics.Instrument(&app.options)
+	}
+	app.expiredSuppressions = expired
+	if suppressFailure := app.options.Suppress; suppressFailure != nil {
+		app.suppressed = make(map[string]bool)
+		app.options.Suppress = func(result linkpatrol.Result) (string, bool) {
+			reason, ok := suppressFailure(result)
+			if ok {
+				app.suppressedMu.Lock()
+				app.suppressed[result.URL] = true
+				app.suppressedMu.Unlock()
+			}
+			return reason, ok
+		}
+	}
+	// Baselines change the exit code, so one is only applied when asked for
+	if cfg.Baseline != "" && !cfg.WriteBaseline {
+		if app.baseline, err = baseline.Load(cfg.Baseline); err != nil {
+			return nil, err
+		}
+	}
+	return app, nil
+}
+
+// streamResults writes each result to path, or standard output for "-", as
+// soon as the checker records it
+func (a *App) streamResults(path string) error {
+	a.ndjsonFile = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("creating NDJSON stream: %w", err)
+		}
+		a.ndjsonFile = f
+	}
+	a.ndjson = linkreport.NewNDJSON(a.ndjsonFile)
+
+	onResult := a.options.OnResult
+	a.options.OnResult = func(result linkpatrol.Result) {
+		a.ndjson.Write(result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	return nil
+}
+
+// NewOptions builds the checker options for cfg, leaving out where progress
+// is written. Every run with the same cfg gets a client of its own, and the
+// suppressions in cfg.IgnoreFile as they are now; the ones that have expired
+// are returned so they can be warned about.
+func NewOptions(cfg *config.Config) (linkpatrol.Options, []suppress.Rule, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return linkpatrol.Options{}, nil, err
+	}
+
+	options := linkpatrol.Options{
+		Targets:              []string{cfg.Target},
+		Client:               client,
+		Walkers:              cfg.Walkers,
+		Testers:              cfg.Testers,
+		MaxPerHost:           cfg.MaxPerHost,
+		RateLimit:            cfg.Rate,
+		Timeout:              cfg.Timeout,
+		GracePeriod:          cfg.GracePeriod,
+		CheckExternalAnchors: cfg.CheckExternalAnchors,
+		MaxAnchorPageSize:    cfg.MaxAnchorPageSize,
+		AnchorIgnoreHosts:    cfg.AnchorIgnoreHosts,
+		Soft404:              cfg.Soft404,
+		Soft404Phrases:       cfg.Soft404Phrases,
+		Verbose:              cfg.Verbose,
+	}
+	if cfg.AutoTune {
+		options.MaxTesters = cfg.MaxTesters
+	}
+	if cfg.Login.URL != "" {
+		options.SkipPaths = cfg.Login.LogoutPatterns
+		if len(options.SkipPaths) == 0 {
+			options.SkipPaths = walker.DefaultLogoutPatterns
+		}
+	}
+
+	suppressions, expired, err := suppress.Load(cfg.IgnoreFile, time.Now())
+	if err != nil {
+		return linkpatrol.Options{}, nil, err
+	}
+	if suppressions != nil {
+		options.Suppress = func(result linkpatrol.Result) (string, bool) {
+			rule, ok := suppressions.Match(result)
+			if !ok {
+				return "", false
+			}
+			return "Suppressed: " + rule.Describe(), true
+		}
+	}
+	return options, expired, nil
+}
+
+// newClient builds the client for every request of the run, with the
+// configured proxies, header profiles, credentials and cookies
+func newClient(cfg *config.Config) (*http.Client, error) {
+	client := workers.NewClient(cfg.Timeout)
+
+	// The target site is usually internal, so it's reached directly unless a proxy route says otherwise
+	var directHosts []string
+	if target, err := url.Parse(cfg.Target); err == nil && target.Host != "" {
+		directHosts = append(directHosts, target.Host)
+	}
+	proxy, err := profiles.NewProxyFunc(cfg.Proxy, cfg.ProxyRoutes, directHosts)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.(*http.Transport).Proxy = proxy
+	// Outside DNS is usually blocked where a proxy is required
+	if profiles.UsesProxy(cfg.Proxy, cfg.ProxyRoutes) {
+		workers.UseSystemResolver(client)
+	}
+
+	// Host profile headers are added first and win over the header profile's
+	headerProfiles, err := profiles.NewHeaderProfiles(cfg.ContactURL, cfg.HeaderProfiles, cfg.HeaderProfile, cfg.HostProfiles, cfg.BotRetryProfile)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = profiles.NewHeaderTransport(client.Transport, headerProfiles)
+	hostProfiles, err := profiles.Resolve(cfg.HostProfiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(hostProfiles) > 0 {
+		client.Transport = profiles.NewTransport(client.Transport, hostProfiles)
+	}
+
+	// A login needs a jar to keep its session even without a cookies file
+	if cfg.CookiesFile != "" || cfg.Login.URL != "" {
+		jar, err := profiles.LoadCookieJar(cfg.CookiesFile)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+	return client, nil
 }
 
 func (a *App) Run(ctx context.Context) error {
 	a.logger.StartSection("LinkPatrol Starting")
-	a.logger.Config(a.config.Target, false, a.config.Concurrency, a.config.Timeout, a.config.Rate)
+	a.logger.Config(a.config.Target, false, a.config.Walkers, a.config.Testers, a.config.Timeout, a.config.Rate)
 
-	// Start worker pool
-	a.logger.Debug("Starting worker pool with %d crawlers and testers", a.config.Concurrency)
-	a.workerPool.Start(ctx)
-	a.cache.DoLoop()
+	for _, rule := range a.expiredSuppressions {
+		a.logger.Warn("Suppression for %s expired on %s and no longer applies (%s)", rule.Pattern, rule.Expires, rule.Describe())
+	}
+	if a.baseline != nil {
+		a.logger.Warn("Applying baseline %s: its %d known failures won't fail the run", a.config.Baseline, len(a.baseline.Failures))
+	}
 
 	// Get target URL from config
 	if a.config.Target == "" {
 		a.logger.Error("No target URL specified. Provide URL as first argument or use --target flag.")
 		return fmt.Errorf("no target URL specified")
 	}
+	checker, err := linkpatrol.New(a.options)
+	if err != nil {
+		a.logger.Error("%s", err)
+		return err
+	}
+	a.checker = checker
+
+	if a.metrics != nil {
+		stopMetrics, err := a.metrics.Serve(a.config.MetricsAddr)
+		if err != nil {
+			a.logger.Error("%s", err)
+			return err
+		}
+		defer stopMetrics()
+		defer a.metrics.Track(checker)()
+		a.logger.Debug("Serving metrics at %s/metrics", a.config.MetricsAddr)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopSignals := a.handleSignals(ctx, cancel)
+	defer stopSignals()
+
+	// Sign in before anything is crawled so every request carries the session
+	if a.config.Login.URL != "" {
+		a.logger.Debug("Logging in at %s", a.config.Login.URL)
+		if err := profiles.Login(ctx, a.client, a.config.Login); err != nil {
+			a.logger.Error("Login failed: %s", err)
+			return err
+		}
+		a.logger.Debug("Logged in at %s", a.config.Login.URL)
+	}
 
-	// Send initial URL to walker
 	a.logger.StartSection("Testing Links")
-	a.workerPool.SendURLs(ctx, a.config.Target)
+	report, err := a.checker.Run(ctx)
+	if err != nil && !errors.Is(err, ErrInterrupted) {
+		return err
+	}
+	return a.finish(report)
+}
+
+// handleSignals cancels ctx on the first SIGINT/SIGTERM so the run can drain
+// and report what it has. A second signal aborts in-flight requests at once.
+// The returned func stops listening for signals.
+func (a *App) handleSignals(ctx context.Context, cancel context.CancelFunc) func() {
+	sigChan := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigChan:
+			a.logger.Shutdown()
+			cancel()
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		}
 
-	return a.runNormalMode()
+		select {
+		case <-sigChan:
+			a.logger.Warn("Second signal received, aborting in-flight requests")
+			a.checker.Abort()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
+
+// finish prints the report and turns failures into the error that sets the exit code.
+// Reports and webhooks are told whether the run passed, so a baseline that
+// forgives every failure passes them too.
+func (a *App) finish(report *linkpatrol.Report) error {
+	a.report(report)
+	err := a.outcome(report)
+	passed := err == nil
+	a.writeReports(report, passed)
+	a.notify(report, passed)
+	return err
 }
 
-func (a *App) runNormalMode() error {
-	a.workerPool.WaitAndClose()
-	a.logger.StartSection("Results")
-	// Clean up ignored results
-	a.cache.CleanUpIgnoredResults()
-	a.logger.CacheTable(a.cache.GetResults(), a.config.NoTruncate)
+// outcome decides whether the run passed, writing the baseline if asked to
+func (a *App) outcome(report *linkpatrol.Report) error {
+	deadCount, timeoutCount := report.FailureCount()
+	if report.Partial {
+		return fmt.Errorf("%w: found %d dead and %d timeout links before stopping", ErrInterrupted, deadCount, timeoutCount)
+	}
+
+	if a.config.WriteBaseline {
+		path := cmp.Or(a.config.Baseline, baseline.DefaultPath)
+		count, err := baseline.Write(path, report.Results)
+		if err != nil {
+			a.logger.Error("%s", err)
+			return err
+		}
+		a.logger.Success("Wrote %d known failures to baseline %s", count, path)
+		return nil
+	}
+
+	if a.baseline != nil {
+		return a.compareBaseline(report)
+	}
 
 	// Check for failures and exit with appropriate code
-	if a.cache.HasFailures() {
-		deadCount, timeoutCount := a.cache.GetFailureCount()
-		a.logger.TestResults(deadCount, timeoutCount)
+	if report.HasFailures() {
 		return fmt.Errorf("link check failed: found %d dead and %d timeout links", deadCount, timeoutCount)
 	}
 
-	a.logger.TestResults(0, 0)
 	return nil
 }
+
+// compareBaseline fails the run only for failures missing from the baseline,
+// and lists baseline entries that now pass so they can be pruned
+func (a *App) compareBaseline(report *linkpatrol.Report) error {
+	diff := a.baseline.Compare(report.Results)
+
+	if len(diff.Known) > 0 {
+		a.logger.Info("%d failures are already in baseline %s", len(diff.Known), a.config.Baseline)
+	}
+	if len(diff.Fixed) > 0 {
+		a.logger.Success("%d links in the baseline no longer fail; run with --write-baseline to prune them:", len(diff.Fixed))
+		for _, entry := range diff.Fixed {
+			a.logger.Success("  %s", entry.URL)
+		}
+	}
+	if len(diff.New) > 0 {
+		a.logger.Error("%d failures are new since the baseline:", len(diff.New))
+		for _, result := range diff.New {
+			a.logger.Error("  %s (%s)", result.URL, result.Status)
+		}
+		return fmt.Errorf("link check failed: found %d failures not in the baseline", len(diff.New))
+	}
+	return nil
+}
+
+// notify sends the summary of the run to the configured webhooks, or prints
+// their payloads on a dry run. A webhook that fails doesn't fail the run.
+func (a *App) notify(report *linkpatrol.Report, passed bool) {
+	if len(a.webhooks) == 0 {
+		return
+	}
+	summary := webhook.NewSummary(a.config.Target, report, passed)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	for _, hook := range a.webhooks {
+		if !hook.Wants(summary) {
+			a.logger.Debug("Not sending webhook %s, the run passed", hook.Name)
+			continue
+		}
+		payload, err := hook.Render(summary)
+		if err != nil {
+			a.logger.Error("%s", err)
+			continue
+		}
+		if a.config.WebhookDryRun {
+			a.logger.Info("Webhook %s payload (dry run, not sent):", hook.Name)
+			fmt.Fprintln(a.output, string(payload))
+			continue
+		}
+		if err := hook.Send(ctx, payload); err != nil {
+			a.logger.Error("Webhook failed: %s", err)
+			continue
+		}
+		a.logger.Success("Sent the results to webhook %s", hook.Name)
+	}
+}
+
+// writeReports writes the report files asked for. A file that can't be
+// written is reported but doesn't change the exit code.
+func (a *App) writeReports(report *linkpatrol.Report, passed bool) {
+	if a.config.HTMLReport != "" {
+		a.writeReport(a.config.HTMLReport, "HTML report", func(w io.Writer) error {
+			return linkreport.WriteHTML(w, a.config.Target, report, time.Now())
+		})
+	}
+	if a.config.CSVReport != "" {
+		a.writeReport(a.config.CSVReport, "CSV report", func(w io.Writer) error {
+			return linkreport.WriteCSV(w, report)
+		})
+	}
+	if a.config.MarkdownReport != "" {
+		a.writeReport(a.config.MarkdownReport, "Markdown summary", func(w io.Writer) error {
+			return linkreport.WriteMarkdown(w, a.config.Target, report, linkreport.MarkdownOptions{
+				MaxSize:   a.config.MarkdownMaxSize,
+				Sources:   a.sources,
+				SourceURL: cmp.Or(a.config.SourceURL, sourcemap.GitHubBaseURL()),
+				Passed:    passed,
+			})
+		})
+	}
+	if a.ndjson != nil {
+		if err := a.ndjson.Err(); err != nil {
+			a.logger.Error("Streaming NDJSON: %s", err)
+		}
+		if a.ndjsonFile != os.Stdout {
+			a.ndjsonFile.Close()
+		}
+	}
+	// Workflow commands are only picked up from standard output
+	if a.config.GitHubAnnotations {
+		if err := linkreport.WriteGitHubAnnotations(os.Stdout, report, a.sources, passed); err != nil {
+			a.logger.Error("Writing GitHub annotations: %s", err)
+		}
+	}
+}
+
+func (a *App) writeReport(path, name string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		a.logger.Error("Writing %s: %s", name, err)
+		return
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		a.logger.Error("Writing %s: %s", name, err)
+		return
+	}
+	a.logger.Success("Wrote %s to %s", name, path)
+}
+
+// report prints the results gathered so far. A partial report is clearly
+// marked so it isn't mistaken for a full crawl.
+func (a *App) report(report *linkpatrol.Report) {
+	if report.Partial {
+		a.logger.StartSection("Results (partial)")
+		a.logger.Warn("Run was interrupted before the crawl finished; the results below are incomplete")
+	} else {
+		a.logger.StartSection("Results")
+	}
+
+	// Ignored links are only worth listing in verbose runs, unless they're failures a suppression muted
+	a.suppressedMu.Lock()
+	results := make([]cache.CacheEntry, 0, len(report.Results))
+	for _, result := range report.Results {
+		if result.Status != linkpatrol.Ignore || a.config.Verbose || a.suppressed[result.URL] {
+			results = append(results, cache.CacheEntry{
+				URL:    result.URL,
+				Status: cache.CacheEntryStatus(result.Status),
+				Error:  result.Error,
+			})
+		}
+	}
+	a.suppressedMu.Unlock()
+	a.logger.CacheTable(results, a.config.NoTruncate)
+
+	// "All links are working" would be misleading for a partial run
+	deadCount, timeoutCount := report.FailureCount()
+	if !report.Partial || deadCount > 0 || timeoutCount > 0 {
+		a.logger.TestResults(deadCount, timeoutCount)
+	}
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/baseline"
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/webhook"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestFinishTellsWebhooksTheBaselineVerdict(t *testing.T) {
+	var received []webhook.Summary
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary webhook.Summary
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Error(err)
+		}
+		received = append(received, summary)
+	}))
+	defer server.Close()
+
+	hooks, err := webhook.New([]config.Webhook{{URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{
+		config:   &config.Config{Target: "https://site.example/", Baseline: "baseline.json"},
+		logger:   logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard)),
+		webhooks: hooks,
+		baseline: &baseline.Baseline{Failures: []baseline.Entry{{URL: "https://site.example/known", Status: "Dead"}}},
+	}
+
+	known := linkpatrol.Result{URL: "https://site.example/known", Status: linkpatrol.Dead}
+	if err := a.finish(&linkpatrol.Report{Results: []linkpatrol.Result{known}}); err != nil {
+		t.Errorf("a run with only known failures failed: %s", err)
+	}
+	fresh := linkpatrol.Result{URL: "https://site.example/new", Status: linkpatrol.Dead}
+	if err := a.finish(&linkpatrol.Report{Results: []linkpatrol.Result{known, fresh}}); err == nil {
+		t.Error("a run with a new failure passed")
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("received %d summaries, want 2", len(received))
+	}
+	if !received[0].Passed || received[0].Broken != 1 {
+		t.Errorf("known failures only: %+v, want passed with the failure still counted", received[0])
+	}
+	if received[1].Passed {
+		t.Errorf("new failure: %+v, want failed", received[1])
+	}
+}
+
+func TestNewRejectsAnnotationsInTheNDJSONStream(t *testing.T) {
+	if _, err := New(&config.Config{Target: "https://site.example/", NDJSON: "-", GitHubAnnotations: true}); err == nil {
+		t.Error("annotations and the NDJSON stream were both given standard output")
+	}
+}
+
+func TestWebhookDryRunPrintsToTheAppOutput(t *testing.T) {
+	hooks, err := webhook.New([]config.Webhook{{URL: "https://hooks.example/", Template: `{"passed": {{.Passed}}}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	a := &App{
+		config:   &config.Config{Target: "https://site.example/", WebhookDryRun: true},
+		logger:   logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard)),
+		output:   &output,
+		webhooks: hooks,
+	}
+	a.notify(&linkpatrol.Report{}, true)
+	if output.String() != "{\"passed\": true}\n" {
+		t.Errorf("output = %q, want the payload", output.String())
+	}
+}
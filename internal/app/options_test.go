@@ -0,0 +1,64 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestNewOptionsAppliesSuppressions(t *testing.T) {
+	ignoreFile := filepath.Join(t.TempDir(), ".linkpatrolignore")
+	err := os.WriteFile(ignoreFile, []byte(`
+- pattern: https://flaky.example/*
+  reason: flaky upstream
+- pattern: https://old.example/*
+  reason: gone
+  expires: 2000-01-01
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, expired, err := NewOptions(&config.Config{
+		Target:        "https://site.example/",
+		Timeout:       time.Second,
+		HeaderProfile: "linkpatrol",
+		IgnoreFile:    ignoreFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Suppress == nil {
+		t.Fatal("suppressions aren't wired into the options")
+	}
+	reason, ok := options.Suppress(linkpatrol.Result{URL: "https://flaky.example/x", Status: linkpatrol.Dead})
+	if !ok || !strings.Contains(reason, "flaky upstream") {
+		t.Errorf("Suppress = %q, %v; want the rule's reason", reason, ok)
+	}
+	if _, ok := options.Suppress(linkpatrol.Result{URL: "https://old.example/x", Status: linkpatrol.Dead}); ok {
+		t.Error("an expired rule still applies")
+	}
+	if len(expired) != 1 || expired[0].Pattern != "https://old.example/*" {
+		t.Errorf("expired = %+v", expired)
+	}
+}
+
+func TestNewOptionsWithoutIgnoreFile(t *testing.T) {
+	options, _, err := NewOptions(&config.Config{
+		Target:        "https://site.example/",
+		Timeout:       time.Second,
+		HeaderProfile: "linkpatrol",
+		IgnoreFile:    filepath.Join(t.TempDir(), "missing"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Suppress != nil {
+		t.Error("Suppress set without an ignore file")
+	}
+}
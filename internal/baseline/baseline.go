@@ -0,0 +1,111 @@
+package baseline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// DefaultPath is where --write-baseline saves the baseline when --baseline isn't set
+const DefaultPath = "linkpatrol-baseline.json"
+
+// Entry is a known failure recorded in a baseline file
+type Entry struct {
+	URL      string `json:"url"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// Baseline is the set of failures a site is known to have, so that only new
+// failures need to fail a run
+type Baseline struct {
+	Failures []Entry `json:"failures"`
+}
+
+// Comparison splits a run's failures by whether the baseline knew about them
+type Comparison struct {
+	New   []linkpatrol.Result // failing now but not in the baseline
+	Known []linkpatrol.Result // failing now and in the baseline
+	Fixed []Entry             // in the baseline but not failing now
+}
+
+// Load reads a baseline file
+func Load(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("baseline %s doesn't exist; create it with --write-baseline", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline: %w", err)
+	}
+
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parsing baseline %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+// Write saves the failing results as the new baseline and returns how many were recorded
+func Write(path string, results []linkpatrol.Result) (int, error) {
+	b := Baseline{Failures: []Entry{}}
+	for _, result := range results {
+		if result.Status.IsFailure() {
+			b.Failures = append(b.Failures, Entry{
+				URL:      result.URL,
+				Status:   result.Status.String(),
+				Error:    result.Error,
+				Referrer: result.Referrer,
+			})
+		}
+	}
+	// Keep the file stable between runs so it diffs cleanly in version control
+	sort.Slice(b.Failures, func(i, j int) bool {
+		return b.Failures[i].URL < b.Failures[j].URL
+	})
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return 0, fmt.Errorf("writing baseline: %w", err)
+	}
+	return len(b.Failures), nil
+}
+
+// Compare sorts the failing results into new and known failures, and finds
+// the baseline entries that no longer fail. A link that fails differently,
+// such as a timeout that is now dead, still counts as known.
+func (b *Baseline) Compare(results []linkpatrol.Result) Comparison {
+	known := make(map[string]bool, len(b.Failures))
+	for _, entry := range b.Failures {
+		known[entry.URL] = true
+	}
+
+	var cmp Comparison
+	failing := make(map[string]bool)
+	for _, result := range results {
+		if !result.Status.IsFailure() {
+			continue
+		}
+		failing[result.URL] = true
+		if known[result.URL] {
+			cmp.Known = append(cmp.Known, result)
+		} else {
+			cmp.New = append(cmp.New, result)
+		}
+	}
+	for _, entry := range b.Failures {
+		if !failing[entry.URL] {
+			cmp.Fixed = append(cmp.Fixed, entry)
+		}
+	}
+	return cmp
+}
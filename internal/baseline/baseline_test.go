@@ -0,0 +1,98 @@
+package baseline
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func urls[T any](items []T, url func(T) string) []string {
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, url(item))
+	}
+	return out
+}
+
+func resultURL(r linkpatrol.Result) string { return r.URL }
+func entryURL(e Entry) string              { return e.URL }
+
+func equal(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCompare(t *testing.T) {
+	b := &Baseline{Failures: []Entry{
+		{URL: "https://a.example/known", Status: "Dead"},
+		{URL: "https://a.example/was-timeout", Status: "Timeout"},
+		{URL: "https://a.example/fixed", Status: "Dead"},
+		{URL: "https://a.example/now-ignored", Status: "Dead"},
+	}}
+	results := []linkpatrol.Result{
+		{URL: "https://a.example/known", Status: linkpatrol.Dead},
+		{URL: "https://a.example/was-timeout", Status: linkpatrol.Dead}, // failing differently still counts as known
+		{URL: "https://a.example/new", Status: linkpatrol.Soft404},
+		{URL: "https://a.example/fixed", Status: linkpatrol.Live},
+		{URL: "https://a.example/now-ignored", Status: linkpatrol.Ignore},
+		{URL: "https://a.example/blocked", Status: linkpatrol.Bot}, // not a failure, so neither new nor known
+	}
+
+	cmp := b.Compare(results)
+	if got, want := urls(cmp.Known, resultURL), []string{"https://a.example/known", "https://a.example/was-timeout"}; !equal(got, want) {
+		t.Errorf("Known = %v, want %v", got, want)
+	}
+	if got, want := urls(cmp.New, resultURL), []string{"https://a.example/new"}; !equal(got, want) {
+		t.Errorf("New = %v, want %v", got, want)
+	}
+	if got, want := urls(cmp.Fixed, entryURL), []string{"https://a.example/fixed", "https://a.example/now-ignored"}; !equal(got, want) {
+		t.Errorf("Fixed = %v, want %v", got, want)
+	}
+}
+
+func TestWriteThenLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultPath)
+	count, err := Write(path, []linkpatrol.Result{
+		{URL: "https://a.example/z", Status: linkpatrol.Dead, Error: "HTTP 404", Referrer: "https://a.example/"},
+		{URL: "https://a.example/ok", Status: linkpatrol.Live},
+		{URL: "https://a.example/a", Status: linkpatrol.Timeout},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("recorded %d failures, want 2", count)
+	}
+
+	b, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sorted by URL so the file diffs cleanly
+	want := []Entry{
+		{URL: "https://a.example/a", Status: "Timeout"},
+		{URL: "https://a.example/z", Status: "Dead", Error: "HTTP 404", Referrer: "https://a.example/"},
+	}
+	if len(b.Failures) != len(want) {
+		t.Fatalf("loaded %+v, want %+v", b.Failures, want)
+	}
+	for i := range want {
+		if b.Failures[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, b.Failures[i], want[i])
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a baseline that doesn't exist succeeded")
+	}
+}
@@ -13,11 +13,13 @@ func _() {
 	_ = x[Dead-2]
 	_ = x[Bot-3]
 	_ = x[Ignore-4]
+	_ = x[MissingAnchor-5]
+	_ = x[Soft404-6]
 }
 
-const _CacheEntryStatus_name = "LiveTimeoutDeadBotIgnore"
+const _CacheEntryStatus_name = "LiveTimeoutDeadBotIgnoreMissingAnchorSoft404"
 
-var _CacheEntryStatus_index = [...]uint8{0, 4, 11, 15, 18, 24}
+var _CacheEntryStatus_index = [...]uint8{0, 4, 11, 15, 18, 24, 37, 44}
 
 func (i CacheEntryStatus) String() string {
 	if i < 0 || i >= CacheEntryStatus(len(_CacheEntryStatus_index)-1) {
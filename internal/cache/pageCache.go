@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Page is what the page cache keeps about a fetched page
+type Page struct {
+	StatusCode  int
+	ContentType string
+	Anchors     map[string]bool // element ids and <a name> values
+	Truncated   bool            // only part of the body was read, so Anchors may be incomplete
+	Error       string          // set when the page couldn't be fetched
+}
+
+type pageEntry struct {
+	ready chan struct{}
+	once  sync.Once
+	page  *Page
+}
+
+func (e *pageEntry) finish(page *Page) {
+	e.once.Do(func() {
+		e.page = page
+		close(e.ready)
+	})
+}
+
+// PageCache remembers the fragment targets found on fetched pages, so fragment
+// links can be checked without fetching the same page again. Pages are keyed by
+// URL without the fragment, which is dropped from the URLs passed in.
+type PageCache struct {
+	mu    sync.Mutex
+	pages map[string]*pageEntry
+}
+
+func NewPageCache() *PageCache {
+	return &PageCache{
+		pages: make(map[string]*pageEntry, 1000),
+	}
+}
+
+// Begin marks pageURL as being fetched by the caller. The returned func must
+// be called with the result; anyone loading the page meanwhile waits for it.
+// If the page is already loaded or being loaded, the returned func does nothing.
+func (c *PageCache) Begin(pageURL string) func(*Page) {
+	entry, leader := c.begin(pageURL)
+	if !leader {
+		return func(*Page) {}
+	}
+	return entry.finish
+}
+
+// Load returns the cached page for pageURL. If another worker is fetching it
+// Load waits for that fetch, and if nobody has fetched it yet fetch is called.
+func (c *PageCache) Load(ctx context.Context, pageURL string, fetch func() *Page) (*Page, error) {
+	entry, leader := c.begin(pageURL)
+	if leader {
+		entry.finish(fetch())
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.page, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// begin returns the entry for pageURL, creating it if needed. leader is true
+// when the caller created it and is responsible for finishing it.
+func (c *PageCache) begin(pageURL string) (entry *pageEntry, leader bool) {
+	pageURL, _, _ = strings.Cut(pageURL, "#")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, exists := c.pages[pageURL]; exists {
+		return entry, false
+	}
+	entry = &pageEntry{ready: make(chan struct{})}
+	c.pages[pageURL] = entry
+	return entry, true
+}
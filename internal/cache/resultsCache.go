@@ -5,9 +5,19 @@ import (
 )
 
 type CacheEntry struct {
-	URL    string
-	Status CacheEntryStatus
-	Error  string
+	URL      string
+	Status   CacheEntryStatus
+	Error    string
+	Referrer string // the page the link was first found on, empty for the start page
+	// Redirects lists every response on the way to the final one, including it,
+	// when the link redirected
+	Redirects []Redirect
+}
+
+// Redirect is one response in a redirect chain
+type Redirect struct {
+	URL        string
+	StatusCode int
 }
 
 //go:generate stringer -type=CacheEntryStatus
@@ -19,13 +29,26 @@ const (
 	Dead
 	Bot
 	Ignore
+	MissingAnchor // the page loaded but has no element matching the link's fragment
+	Soft404       // the page answered 2xx but is really a "not found" page
 )
 
+// IsFailure reports whether the status means the link is broken or timed out
+func (s CacheEntryStatus) IsFailure() bool {
+	return s == Dead || s == Timeout || s == MissingAnchor || s == Soft404
+}
+
 type ResultsCache struct {
 	ResultsData  map[string]CacheEntry
 	ClaimedURLs  map[string]bool
 	ResultsMutex sync.RWMutex
 	ResultsChan  <-chan CacheEntry
+	loopDone     chan struct{}
+	stop         chan struct{}
+	stopOnce     sync.Once
+	onResult     []func(CacheEntry)
+	rewrite      func(CacheEntry) CacheEntry
+	skipped      map[string]CacheEntry // guarded by ResultsMutex
 }
 
 func NewResultsCache(resultsReadChan <-chan CacheEntry) *ResultsCache {
@@ -33,6 +56,9 @@ func NewResultsCache(resultsReadChan <-chan CacheEntry) *ResultsCache {
 		ResultsData: make(map[string]CacheEntry, 1000),
 		ClaimedURLs: make(map[string]bool, 1000),
 		ResultsChan: resultsReadChan,
+		skipped:     make(map[string]CacheEntry),
+		loopDone:    make(chan struct{}),
+		stop:        make(chan struct{}),
 	}
 }
 
@@ -81,32 +107,130 @@ func (c *ResultsCache) GetResults() []CacheEntry {
 	return results
 }
 
+// OnResult registers fn to be called with every result as DoLoop records it.
+// It must be called before DoLoop.
+func (c *ResultsCache) OnResult(fn func(CacheEntry)) {
+	c.onResult = append(c.onResult, fn)
+}
+
+// Rewrite sets fn to adjust each result before it's recorded, e.g. to mark
+// known failures as ignored. It must be called before DoLoop.
+func (c *ResultsCache) Rewrite(fn func(CacheEntry) CacheEntry) {
+	c.rewrite = fn
+}
+
+// Skip records result once the run is over, unless something else claimed
+// or recorded its URL by then. It's for links one page says not to check,
+// which another page may still link to.
+func (c *ResultsCache) Skip(result CacheEntry) {
+	c.ResultsMutex.Lock()
+	defer c.ResultsMutex.Unlock()
+	if _, exists := c.skipped[result.URL]; !exists {
+		c.skipped[result.URL] = result
+	}
+}
+
 func (c *ResultsCache) DoLoop() {
 	go func() {
-		for result := range c.ResultsChan {
-			c.ResultsMutex.Lock()
-			c.ResultsData[result.URL] = result
-			// Remove from claimed when we have a result
-			delete(c.ClaimedURLs, result.URL)
-			c.ResultsMutex.Unlock()
+		defer close(c.loopDone)
+		defer c.recordSkipped()
+		for {
+			select {
+			case result, ok := <-c.ResultsChan:
+				if !ok {
+					return
+				}
+				c.record(result)
+			case <-c.stop:
+				// Record what was sent before Stop, without waiting for more
+				for {
+					select {
+					case result, ok := <-c.ResultsChan:
+						if !ok {
+							return
+						}
+						c.record(result)
+					default:
+						return
+					}
+				}
+			}
 		}
 	}()
 }
 
+func (c *ResultsCache) record(result CacheEntry) {
+	if c.rewrite != nil {
+		result = c.rewrite(result)
+	}
+	c.ResultsMutex.Lock()
+	c.ResultsData[result.URL] = result
+	// Remove from claimed when we have a result
+	delete(c.ClaimedURLs, result.URL)
+	c.ResultsMutex.Unlock()
+
+	for _, fn := range c.onResult {
+		fn(result)
+	}
+}
+
+// recordSkipped records the skipped links nothing else checked
+func (c *ResultsCache) recordSkipped() {
+	c.ResultsMutex.RLock()
+	var unchecked []CacheEntry
+	for url, result := range c.skipped {
+		_, recorded := c.ResultsData[url]
+		if !recorded && !c.ClaimedURLs[url] {
+			unchecked = append(unchecked, result)
+		}
+	}
+	c.ResultsMutex.RUnlock()
+
+	for _, result := range unchecked {
+		c.record(result)
+	}
+}
+
+// Stop makes DoLoop record the results already sent and return, for when the
+// results channel can't be closed because a sender may still be running
+func (c *ResultsCache) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Wait blocks until DoLoop has recorded every result, which happens once the
+// results channel is closed or Stop is called
+func (c *ResultsCache) Wait() {
+	<-c.loopDone
+}
+
+// Count returns the number of recorded results
+func (c *ResultsCache) Count() int {
+	c.ResultsMutex.RLock()
+	defer c.ResultsMutex.RUnlock()
+	return len(c.ResultsData)
+}
+
 func (c *ResultsCache) HasFailures() bool {
+	c.ResultsMutex.RLock()
+	defer c.ResultsMutex.RUnlock()
+
 	for _, result := range c.ResultsData {
-		if result.Status == Dead || result.Status == Timeout {
+		if result.Status.IsFailure() {
 			return true
 		}
 	}
 	return false
 }
 
+// GetFailureCount returns the number of broken links (dead, soft 404 or missing their anchor) and timed out links
 func (c *ResultsCache) GetFailureCount() (int, int) {
+	c.ResultsMutex.RLock()
+	defer c.ResultsMutex.RUnlock()
+
 	deadCount := 0
 	timeoutCount := 0
 	for _, result := range c.ResultsData {
-		if result.Status == Dead {
+		if result.Status == Dead || result.Status == MissingAnchor || result.Status == Soft404 {
 			deadCount++
 		}
 		if result.Status == Timeout {
@@ -117,6 +241,9 @@ func (c *ResultsCache) GetFailureCount() (int, int) {
 }
 
 func (c *ResultsCache) CleanUpIgnoredResults() {
+	c.ResultsMutex.Lock()
+	defer c.ResultsMutex.Unlock()
+
 	for url, result := range c.ResultsData {
 		if result.Status == Ignore {
 			delete(c.ResultsData, url)
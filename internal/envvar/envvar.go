@@ -0,0 +1,31 @@
+// Package envvar expands environment variables written as ${NAME} in
+// configuration values, so secrets can be kept out of config files
+package envvar
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// braced matches ${NAME}; a bare $ is left alone, as passwords and URLs may contain one
+var braced = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Expand replaces each ${NAME} in s with the value of the environment
+// variable NAME. It fails on a variable that isn't set rather than leaving a
+// hole where a secret should be. The error names the variable, never s.
+func Expand(s string) (string, error) {
+	var missing string
+	expanded := braced.ReplaceAllStringFunc(s, func(match string) string {
+		name := match[2 : len(match)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+	return expanded, nil
+}
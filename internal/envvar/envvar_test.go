@@ -0,0 +1,38 @@
+package envvar
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	t.Setenv("HOOK_PATH", "T000/B000/token")
+	t.Setenv("word", "oops")
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://hooks.example.com/${HOOK_PATH}", "https://hooks.example.com/T000/B000/token"},
+		{"${HOOK_PATH}-${HOOK_PATH}", "T000/B000/token-T000/B000/token"},
+		{"pa$word", "pa$word"},
+		{"$HOOK_PATH", "$HOOK_PATH"},
+		{"${1NOT_A_NAME}", "${1NOT_A_NAME}"},
+		{"x${EMPTY}y", "xy"},
+	}
+	for _, tt := range tests {
+		got, err := Expand(tt.raw)
+		if err != nil {
+			t.Errorf("Expand(%q): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestExpandFailsOnUnsetVariables(t *testing.T) {
+	t.Setenv("HOOK_PATH", "T000/B000/token")
+	_, err := Expand("https://hooks.example.com/${HOOK_PATH}/${HOOK_UNSET}")
+	if err == nil || err.Error() != "environment variable HOOK_UNSET is not set" {
+		t.Errorf("err = %v, want one naming HOOK_UNSET", err)
+	}
+}
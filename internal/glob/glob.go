@@ -0,0 +1,18 @@
+// Package glob compiles the URL and path patterns used in configuration,
+// where * matches any run of characters and everything else is literal
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile turns pattern into an anchored regexp. Each * becomes a capture
+// group, so what it matched can be substituted elsewhere.
+func Compile(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "(.*)") + "$")
+}
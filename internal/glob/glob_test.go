@@ -0,0 +1,35 @@
+package glob
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"https://example.com/page", "https://example.com/page", true},
+		{"https://example.com/page", "https://example.com/page2", false}, // anchored at the end
+		{"https://example.com/page", "http://x/?https://example.com/page", false},
+		{"https://example.com/*", "https://example.com/a/b?c=d", true},
+		{"https://*.example.com/*", "https://docs.example.com/guide", true},
+		{"https://*.example.com/*", "https://example.com/guide", false},
+		{"https://example.com/a.b", "https://example.com/aXb", false}, // regexp characters are literal
+		{"https://example.com/?q=(1)", "https://example.com/?q=(1)", true},
+	}
+	for _, tt := range tests {
+		if got := Compile(tt.pattern).MatchString(tt.url); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCompileCapturesEachStar(t *testing.T) {
+	captures := Compile("/docs/*/v*.html").FindStringSubmatch("/docs/guide/setup/v2.html")
+	if want := []string{"/docs/guide/setup/v2.html", "guide/setup", "2"}; !slices.Equal(captures, want) {
+		t.Errorf("captures = %q, want %q", captures, want)
+	}
+}
@@ -227,7 +227,7 @@ func (l *Logger) RateLimit(domain string, rate int, action string) {
 }
 
 // Config logs configuration information
-func (l *Logger) Config(dir string, watch bool, concurrency int, timeout, rateLimit any) {
+func (l *Logger) Config(dir string, watch bool, walkers, testers int, timeout, rateLimit any) {
 	if !l.verbose {
 		return
 	}
@@ -235,8 +235,8 @@ func (l *Logger) Config(dir string, watch bool, concurrency int, timeout, rateLi
 	l.log(l.out, "🔧", colorBlue, "Configuration")
 	fmt.Fprintf(l.out, "  %sDirectory:%s %s\n", colorCyan, colorReset, dir)
 	fmt.Fprintf(l.out, "  %sWatch:%s %t\n", colorCyan, colorReset, watch)
-	fmt.Fprintf(l.out, "  %sWalker concurrency:%s %d\n", colorCyan, colorReset, concurrency)
-	fmt.Fprintf(l.out, "  %sTester concurrency:%s %d\n", colorCyan, colorReset, concurrency)
+	fmt.Fprintf(l.out, "  %sWalker concurrency:%s %d\n", colorCyan, colorReset, walkers)
+	fmt.Fprintf(l.out, "  %sTester concurrency:%s %d\n", colorCyan, colorReset, testers)
 	fmt.Fprintf(l.out, "  %sTimeout:%s %v\n", colorCyan, colorReset, timeout)
 	fmt.Fprintf(l.out, "  %sRate limit:%s %v req/s\n", colorCyan, colorReset, rateLimit)
 }
@@ -314,6 +314,8 @@ func (l *Logger) CacheTable(entries []cache.CacheEntry, truncate bool) {
 			color = colorMagenta
 		case cache.Dead:
 			color = colorRed
+		case cache.MissingAnchor, cache.Soft404:
+			color = colorRed
 		}
 
 		emoji := ""
@@ -326,6 +328,12 @@ func (l *Logger) CacheTable(entries []cache.CacheEntry, truncate bool) {
 			emoji = "🤖"
 		case cache.Dead:
 			emoji = "❌"
+		case cache.MissingAnchor:
+			emoji = "⚓"
+		case cache.Soft404:
+			emoji = "👻"
+		case cache.Ignore:
+			emoji = "🔕"
 		}
 
 		displayEntries = append(displayEntries, DisplayEntry{
@@ -351,11 +359,11 @@ func (l *Logger) CacheTable(entries []cache.CacheEntry, truncate bool) {
 	}
 
 	// Calculate dynamic column widths based on terminal width
-	const statusColWidth = 8 // "TIMEOUT" = 7 chars + padding
-	const emojiColWidth = 6  // Emoji + padding
-	const minUrlWidth = 30   // Minimum URL width
-	const minErrorWidth = 15 // Minimum error width
-	const padding = 6        // Space for separators and padding
+	const statusColWidth = 14 // "MissingAnchor" = 13 chars + padding
+	const emojiColWidth = 6   // Emoji + padding
+	const minUrlWidth = 30    // Minimum URL width
+	const minErrorWidth = 15  // Minimum error width
+	const padding = 6         // Space for separators and padding
 
 	// Calculate available space for URL and Error columns (70:30 split)
 	fixedWidth := statusColWidth + emojiColWidth + padding
@@ -0,0 +1,311 @@
+// Package metrics exposes the progress of link checks in the OpenMetrics
+// text format, so crawls can be graphed and alerted on by Prometheus
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// ContentType is the media type of the OpenMetrics text format
+const ContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// statuses are the result statuses, always exported so a status with no results reads 0 rather than missing
+var statuses = []linkpatrol.Status{linkpatrol.Live, linkpatrol.Timeout, linkpatrol.Dead, linkpatrol.Bot, linkpatrol.Ignore, linkpatrol.MissingAnchor, linkpatrol.Soft404}
+
+// Host label values used unless per-host labels are on: links to one of the
+// run's targets are internal, every other link is external
+const (
+	internalHosts = "internal"
+	externalHosts = "external"
+)
+
+type requestKey struct {
+	host  string // the host, or internal or external
+	class string // 2xx, 3xx, 4xx, 5xx or error
+}
+
+type rateLimitWaits struct {
+	count   uint64
+	seconds float64
+}
+
+// Metrics collects what runs instrumented with it are doing. Its counters add
+// up every run since it was created; its gauges sum the runs in progress.
+type Metrics struct {
+	mu             sync.Mutex
+	requests       map[requestKey]uint64
+	latencyCounts  []uint64 // per bucket, not cumulative; the last one is +Inf
+	latencyCount   uint64
+	latencySum     float64
+	rateLimitWaits map[string]*rateLimitWaits // by host
+	results        map[linkpatrol.Status]uint64
+
+	checkers map[*linkpatrol.Checker]struct{}
+
+	// perHost labels requests and rate limit waits with their host. A crawl can
+	// reach any number of hosts, so it's off unless asked for.
+	perHost bool
+}
+
+// New returns metrics labelling requests internal or external, or with their
+// host if perHost is set
+func New(perHost bool) *Metrics {
+	return &Metrics{
+		perHost:        perHost,
+		requests:       make(map[requestKey]uint64),
+		latencyCounts:  make([]uint64, len(latencyBuckets)+1),
+		rateLimitWaits: make(map[string]*rateLimitWaits),
+		results:        make(map[linkpatrol.Status]uint64),
+		checkers:       make(map[*linkpatrol.Checker]struct{}),
+	}
+}
+
+// Instrument makes runs with opts report their requests, rate limit waits and
+// results. Requests are only counted if opts.Client is set; it's copied so
+// other users of the client aren't counted.
+func (m *Metrics) Instrument(opts *linkpatrol.Options) {
+	hostLabel := m.hostLabeller(opts.Targets)
+	if opts.Client != nil {
+		client := *opts.Client
+		client.Transport = &transport{base: client.Transport, metrics: m, hostLabel: hostLabel}
+		opts.Client = &client
+	}
+
+	onResult := opts.OnResult
+	opts.OnResult = func(result linkpatrol.Result) {
+		m.recordResult(result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+
+	onRateLimitWait := opts.OnRateLimitWait
+	opts.OnRateLimitWait = func(host string, waited time.Duration) {
+		m.recordRateLimitWait(hostLabel(host), waited)
+		if onRateLimitWait != nil {
+			onRateLimitWait(host, waited)
+		}
+	}
+}
+
+// hostLabeller returns what a host is labelled as in a run of targets
+func (m *Metrics) hostLabeller(targets []string) func(host string) string {
+	if m.perHost {
+		return func(host string) string { return host }
+	}
+	internal := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if u, err := url.Parse(target); err == nil {
+			internal[u.Host] = true
+		}
+	}
+	return func(host string) string {
+		if internal[host] {
+			return internalHosts
+		}
+		return externalHosts
+	}
+}
+
+// Track adds checker's live stats to the gauges until the returned func is called
+func (m *Metrics) Track(checker *linkpatrol.Checker) func() {
+	m.mu.Lock()
+	m.checkers[checker] = struct{}{}
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		delete(m.checkers, checker)
+		m.mu.Unlock()
+	}
+}
+
+// Handler serves the metrics in the OpenMetrics text format
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		m.Write(w)
+	})
+}
+
+// Serve serves Handler at /metrics on addr in the background until the
+// returned func is called
+func (m *Metrics) Serve(addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("serving metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	return func() { server.Close() }, nil
+}
+
+func (m *Metrics) recordRequest(host, class string, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{host: host, class: class}]++
+
+	seconds := took.Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, seconds)
+	m.latencyCounts[bucket]++
+	m.latencyCount++
+	m.latencySum += seconds
+}
+
+func (m *Metrics) recordRateLimitWait(host string, waited time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	waits := m.rateLimitWaits[host]
+	if waits == nil {
+		waits = &rateLimitWaits{}
+		m.rateLimitWaits[host] = waits
+	}
+	waits.count++
+	waits.seconds += waited.Seconds()
+}
+
+func (m *Metrics) recordResult(result linkpatrol.Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[result.Status]++
+}
+
+// Write writes every metric to w in the OpenMetrics text format
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	var b strings.Builder
+
+	// Gauges, summed over the runs in progress
+	var stats linkpatrol.Stats
+	for checker := range m.checkers {
+		if current, ok := checker.Stats(); ok {
+			stats.ActiveWalkers += current.ActiveWalkers
+			stats.ActiveTesters += current.ActiveTesters
+			stats.PathsToWalk += current.PathsToWalk
+			stats.ResultsToTest += current.ResultsToTest
+		}
+	}
+	family(&b, "linkpatrol_running_checks", "gauge", "Link checks in progress.")
+	sample(&b, "linkpatrol_running_checks", nil, float64(len(m.checkers)))
+	family(&b, "linkpatrol_active_walkers", "gauge", "Walkers crawling a page.")
+	sample(&b, "linkpatrol_active_walkers", nil, float64(stats.ActiveWalkers))
+	family(&b, "linkpatrol_active_testers", "gauge", "Testers checking a link.")
+	sample(&b, "linkpatrol_active_testers", nil, float64(stats.ActiveTesters))
+	family(&b, "linkpatrol_queue_depth", "gauge", "Pages waiting to be crawled and links waiting to be tested.")
+	sample(&b, "linkpatrol_queue_depth", []string{"queue", "walk"}, float64(stats.PathsToWalk))
+	sample(&b, "linkpatrol_queue_depth", []string{"queue", "test"}, float64(stats.ResultsToTest))
+
+	family(&b, "linkpatrol_requests", "counter", "HTTP requests sent, by host and status class.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].class < keys[j].class
+	})
+	for _, key := range keys {
+		sample(&b, "linkpatrol_requests_total", []string{"host", key.host, "class", key.class}, float64(m.requests[key]))
+	}
+
+	family(&b, "linkpatrol_request_duration_seconds", "histogram", "Time from sending a request to receiving its response headers.")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += m.latencyCounts[i]
+		sample(&b, "linkpatrol_request_duration_seconds_bucket", []string{"le", strconv.FormatFloat(bound, 'g', -1, 64)}, float64(cumulative))
+	}
+	sample(&b, "linkpatrol_request_duration_seconds_bucket", []string{"le", "+Inf"}, float64(m.latencyCount))
+	sample(&b, "linkpatrol_request_duration_seconds_sum", nil, m.latencySum)
+	sample(&b, "linkpatrol_request_duration_seconds_count", nil, float64(m.latencyCount))
+
+	hosts := make([]string, 0, len(m.rateLimitWaits))
+	for host := range m.rateLimitWaits {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	family(&b, "linkpatrol_rate_limit_waits", "counter", "Requests held back by their host's rate limit.")
+	for _, host := range hosts {
+		sample(&b, "linkpatrol_rate_limit_waits_total", []string{"host", host}, float64(m.rateLimitWaits[host].count))
+	}
+	family(&b, "linkpatrol_rate_limit_wait_seconds", "counter", "Time requests spent waiting for their host's rate limit.")
+	for _, host := range hosts {
+		sample(&b, "linkpatrol_rate_limit_wait_seconds_total", []string{"host", host}, m.rateLimitWaits[host].seconds)
+	}
+
+	family(&b, "linkpatrol_results", "counter", "Checked links, by result status.")
+	for _, status := range statuses {
+		sample(&b, "linkpatrol_results_total", []string{"status", status.String()}, float64(m.results[status]))
+	}
+
+	b.WriteString("# EOF\n")
+	m.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func family(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# TYPE %s %s\n# HELP %s %s\n", name, metricType, name, help)
+}
+
+// sample writes one sample; labels alternate between names and values
+func sample(b *strings.Builder, name string, labels []string, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// transport counts every request sent through it and times its response
+type transport struct {
+	base      http.RoundTripper
+	metrics   *Metrics
+	hostLabel func(host string) string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	class := "error"
+	if err == nil {
+		class = fmt.Sprintf("%dxx", resp.StatusCode/100)
+	}
+	t.metrics.recordRequest(t.hostLabel(req.URL.Host), class, time.Since(start))
+	return resp, err
+}
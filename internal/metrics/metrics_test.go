@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// instrumented sends a request to each URL through a client instrumented for a run of targets,
+// reports a rate limit wait for each of their hosts and returns the metrics written
+func instrumented(t *testing.T, perHost bool, targets []string, urls ...string) string {
+	t.Helper()
+	m := New(perHost)
+	opts := linkpatrol.Options{
+		Targets: targets,
+		Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})},
+	}
+	m.Instrument(&opts)
+	for _, u := range urls {
+		resp, err := opts.Client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		opts.OnRateLimitWait(resp.Request.URL.Host, time.Second)
+	}
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestHostsAreBucketedByDefault(t *testing.T) {
+	out := instrumented(t, false, []string{"https://site.example/docs"},
+		"https://site.example/a", "https://site.example/b", "https://one.example/", "https://two.example/")
+
+	for _, want := range []string{
+		`linkpatrol_requests_total{host="internal",class="2xx"} 2`,
+		`linkpatrol_requests_total{host="external",class="2xx"} 2`,
+		`linkpatrol_rate_limit_waits_total{host="internal"} 2`,
+		`linkpatrol_rate_limit_waits_total{host="external"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "one.example") {
+		t.Errorf("a host is used as a label value:\n%s", out)
+	}
+}
+
+func TestPerHostLabels(t *testing.T) {
+	out := instrumented(t, true, []string{"https://site.example/"}, "https://site.example/a", "https://one.example/")
+
+	for _, want := range []string{
+		`linkpatrol_requests_total{host="site.example",class="2xx"} 1`,
+		`linkpatrol_requests_total{host="one.example",class="2xx"} 1`,
+		`linkpatrol_rate_limit_waits_total{host="one.example"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+}
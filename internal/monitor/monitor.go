@@ -0,0 +1,238 @@
+// Package monitor re-runs a link check on a schedule and alerts when links
+// break or recover, rather than on every failure of every run
+package monitor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/app"
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/metrics"
+	"github.com/sirprodigle/linkpatrol/internal/profiles"
+	"github.com/sirprodigle/linkpatrol/internal/webhook"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// DefaultMetricsAddr is where metrics are served when --metrics-addr isn't set,
+// since a monitor always runs long enough to be scraped. It's loopback only, so
+// serving them on other interfaces is a choice, and on a port Prometheus
+// itself doesn't use.
+const DefaultMetricsAddr = "127.0.0.1:9464"
+
+// Notifier delivers the alerts of a run
+type Notifier interface {
+	Notify(ctx context.Context, report *linkpatrol.Report, alerts []Alert) error
+}
+
+// Monitor checks the target every interval, keeping what it learns between runs
+type Monitor struct {
+	config    *config.Config
+	logger    *logger.Logger
+	tracker   *Tracker
+	metrics   *metrics.Metrics
+	notifiers []Notifier
+
+	// warnedExpired holds the expired suppressions already warned about
+	warnedExpired map[string]bool
+}
+
+func New(cfg *config.Config) (*Monitor, error) {
+	if cfg.Target == "" {
+		return nil, errors.New("no target URL specified. Provide URL as first argument or use --target flag")
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	// The results of n runs can only change n-1 times, so a higher threshold never trips
+	if cfg.FlapThreshold > 0 && cfg.FlapThreshold >= cfg.FlapWindow {
+		return nil, fmt.Errorf("flap-threshold (%d) must be below flap-window (%d), or 0 to turn flap damping off", cfg.FlapThreshold, cfg.FlapWindow)
+	}
+
+	log := logger.New(cfg.Verbose)
+	notifiers := []Notifier{&logNotifier{logger: log}}
+	hooks, err := webhook.New(cfg.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) > 0 {
+		notifiers = append(notifiers, &webhookNotifier{target: cfg.Target, hooks: hooks, dryRun: cfg.WebhookDryRun, logger: log, output: os.Stdout})
+	}
+
+	return &Monitor{
+		config:    cfg,
+		logger:    log,
+		tracker:   NewTracker(cfg.FailureThreshold, cfg.FlapWindow, cfg.FlapThreshold),
+		metrics:   metrics.New(cfg.MetricsPerHost),
+		notifiers: notifiers,
+
+		warnedExpired: make(map[string]bool),
+	}, nil
+}
+
+// Run checks the target straight away and then every interval, measured from
+// the start of each run, until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) error {
+	metricsAddr := cmp.Or(m.config.MetricsAddr, DefaultMetricsAddr)
+	stopMetrics, err := m.metrics.Serve(metricsAddr)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	m.logger.StartSection("LinkPatrol Monitoring")
+	m.logger.Info("Checking %s every %s, metrics at %s/metrics", m.config.Target, m.config.Interval, metricsAddr)
+
+	for run := 1; ; run++ {
+		started := time.Now()
+		if err := m.check(ctx, run); err != nil {
+			if ctx.Err() != nil {
+				m.logger.Shutdown()
+				return nil
+			}
+			m.logger.Error("Run %d failed: %s", run, err)
+		}
+
+		next := started.Add(m.config.Interval)
+		m.logger.Debug("Next run at %s", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			m.logger.Shutdown()
+			return nil
+		}
+	}
+}
+
+// check runs one crawl with a fresh checker and client, then alerts on the
+// links whose state it changed
+func (m *Monitor) check(ctx context.Context, run int) error {
+	options, expired, err := app.NewOptions(m.config)
+	if err != nil {
+		return err
+	}
+	// The ignore file is re-read every run, but each expired entry only needs pointing out once
+	for _, rule := range expired {
+		if key := rule.Pattern + " " + rule.Pages + " " + rule.Expires; !m.warnedExpired[key] {
+			m.warnedExpired[key] = true
+			m.logger.Warn("Suppression for %s expired on %s and no longer applies (%s)", rule.Pattern, rule.Expires, rule.Describe())
+		}
+	}
+	options.Output = io.Discard
+	if m.config.Verbose {
+		options.Output = os.Stdout
+	}
+	m.metrics.Instrument(&options)
+	checker, err := linkpatrol.New(options)
+	if err != nil {
+		return err
+	}
+	defer m.metrics.Track(checker)()
+
+	if m.config.Login.URL != "" {
+		if err := profiles.Login(ctx, options.Client, m.config.Login); err != nil {
+			return fmt.Errorf("login failed: %w", err)
+		}
+	}
+
+	report, err := checker.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	alerts := m.tracker.Update(report.Results, time.Now())
+	counts := m.tracker.Counts()
+	m.logger.Info("Run %d checked %d links: %d up, %d down, %d flapping, %d state changes",
+		run, len(report.Results), counts[Up], counts[Down], counts[Flapping], len(alerts))
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	for _, notifier := range m.notifiers {
+		if err := notifier.Notify(ctx, report, alerts); err != nil {
+			m.logger.Error("Sending alerts: %s", err)
+		}
+	}
+	return nil
+}
+
+// logNotifier prints alerts to the terminal
+type logNotifier struct {
+	logger *logger.Logger
+}
+
+func (n *logNotifier) Notify(ctx context.Context, report *linkpatrol.Report, alerts []Alert) error {
+	for _, alert := range alerts {
+		switch alert.Current {
+		case Down:
+			n.logger.Error("DOWN %s (was %s, failed %d runs in a row): %s %s", alert.URL, alert.Previous, alert.Failures, alert.Result.Status, alert.Result.Error)
+		case Up:
+			n.logger.Success("UP %s (was %s)", alert.URL, alert.Previous)
+		case Flapping:
+			n.logger.Warn("FLAPPING %s (was %s), alerts are held until it settles", alert.URL, alert.Previous)
+		}
+	}
+	return nil
+}
+
+// webhookNotifier sends alerts to the configured webhooks, with the run's
+// summary and the state changes in .Alerts. The run passed unless a link went
+// down or started flapping.
+type webhookNotifier struct {
+	target string
+	hooks  []*webhook.Hook
+	dryRun bool
+	logger *logger.Logger
+	output io.Writer // gets the payloads of dry runs
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, report *linkpatrol.Report, alerts []Alert) error {
+	passed := true
+	for _, alert := range alerts {
+		if alert.Current != Up {
+			passed = false
+		}
+	}
+	summary := webhook.NewSummary(n.target, report, passed)
+	for _, alert := range alerts {
+		summary.Alerts = append(summary.Alerts, webhook.Alert{
+			URL:      alert.URL,
+			Referrer: alert.Referrer,
+			Previous: string(alert.Previous),
+			Current:  string(alert.Current),
+			Status:   alert.Result.Status.String(),
+			Error:    alert.Result.Error,
+			Failures: alert.Failures,
+		})
+	}
+
+	var errs []error
+	for _, hook := range n.hooks {
+		if !hook.Wants(summary) {
+			n.logger.Debug("Not sending webhook %s, only links recovered", hook.Name)
+			continue
+		}
+		payload, err := hook.Render(summary)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if n.dryRun {
+			n.logger.Info("Webhook %s payload (dry run, not sent):", hook.Name)
+			fmt.Fprintln(n.output, string(payload))
+			continue
+		}
+		if err := hook.Send(ctx, payload); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n.logger.Success("Sent %d alerts to webhook %s", len(alerts), hook.Name)
+	}
+	return errors.Join(errs...)
+}
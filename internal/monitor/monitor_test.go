@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/webhook"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestNewRejectsUnreachableFlapThreshold(t *testing.T) {
+	tests := []struct {
+		window, threshold int
+		ok                bool
+	}{
+		{10, 4, true},
+		{10, 9, true},
+		{10, 10, false},
+		{4, 10, false},
+		{4, 0, true}, // off
+	}
+	for _, tt := range tests {
+		_, err := New(&config.Config{Target: "https://site.example/", Interval: time.Minute, FlapWindow: tt.window, FlapThreshold: tt.threshold})
+		if (err == nil) != tt.ok {
+			t.Errorf("flap-window %d, flap-threshold %d: err = %v", tt.window, tt.threshold, err)
+		}
+	}
+}
+
+func TestWebhookNotifierSendsAlerts(t *testing.T) {
+	received := make(chan webhook.Summary, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary webhook.Summary
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Error(err)
+		}
+		received <- summary
+	}))
+	defer server.Close()
+
+	hooks, err := webhook.New([]config.Webhook{{URL: server.URL, SendOn: "failure"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &webhookNotifier{
+		target: "https://site.example/",
+		hooks:  hooks,
+		logger: logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard)),
+	}
+	down := linkpatrol.Result{URL: "https://site.example/gone", Status: linkpatrol.Dead, Error: "HTTP 404", Referrer: "https://site.example/"}
+	report := &linkpatrol.Report{Results: []linkpatrol.Result{down}}
+
+	alerts := []Alert{{URL: down.URL, Referrer: down.Referrer, Previous: Up, Current: Down, Result: down, Failures: 2, At: time.Now()}}
+	if err := n.Notify(context.Background(), report, alerts); err != nil {
+		t.Fatal(err)
+	}
+	summary := <-received
+	if summary.Passed || summary.Target != "https://site.example/" || len(summary.Alerts) != 1 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	want := webhook.Alert{URL: down.URL, Referrer: down.Referrer, Previous: "up", Current: "down", Status: "Dead", Error: "HTTP 404", Failures: 2}
+	if summary.Alerts[0] != want {
+		t.Errorf("alert = %+v, want %+v", summary.Alerts[0], want)
+	}
+
+	// A send-on failure webhook isn't sent when links only recovered
+	up := linkpatrol.Result{URL: down.URL, Status: linkpatrol.Live}
+	report = &linkpatrol.Report{Results: []linkpatrol.Result{up}}
+	if err := n.Notify(context.Background(), report, []Alert{{URL: up.URL, Previous: Down, Current: Up, Result: up}}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case summary := <-received:
+		t.Errorf("sent %+v for a recovery", summary)
+	default:
+	}
+}
+
+func TestWebhookNotifierDryRunWritesToItsOutput(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	hooks, err := webhook.New([]config.Webhook{{URL: server.URL, Template: `{"down": {{len .Alerts}}}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	n := &webhookNotifier{
+		target: "https://site.example/",
+		hooks:  hooks,
+		dryRun: true,
+		logger: logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard)),
+		output: &output,
+	}
+	down := linkpatrol.Result{URL: "https://site.example/gone", Status: linkpatrol.Dead}
+	alerts := []Alert{{URL: down.URL, Previous: Up, Current: Down, Result: down}}
+	if err := n.Notify(context.Background(), &linkpatrol.Report{Results: []linkpatrol.Result{down}}, alerts); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); got != "{\"down\": 1}\n" {
+		t.Errorf("output = %q, want the payload", got)
+	}
+	if requests != 0 {
+		t.Errorf("a dry run sent %d requests", requests)
+	}
+}
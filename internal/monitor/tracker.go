@@ -0,0 +1,143 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// State is what the monitor currently believes about a link
+type State string
+
+const (
+	Unknown  State = "unknown"  // not checked often enough to tell
+	Up       State = "up"       // the link works
+	Down     State = "down"     // the link failed enough runs in a row to alert
+	Flapping State = "flapping" // the link keeps switching between working and failing
+)
+
+// Alert reports a link whose state changed
+type Alert struct {
+	URL      string
+	Referrer string
+	Previous State
+	Current  State
+	// Result is the check of the link in the run that changed its state
+	Result linkpatrol.Result
+	// Failures is the number of runs in a row the link has failed
+	Failures int
+	At       time.Time
+}
+
+// linkState is what the tracker remembers about a link between runs
+type linkState struct {
+	state    State
+	failures int    // consecutive failing runs
+	history  []bool // whether the link failed, for the last flapWindow runs it was checked in
+}
+
+// Tracker keeps the results of every run and turns them into alerts when a
+// link's state changes. A link is only Down after failureThreshold failing
+// runs in a row, and a link whose result changed flapThreshold times within
+// its last flapWindow runs is Flapping, so it raises one alert rather than one
+// per change.
+type Tracker struct {
+	failureThreshold int
+	flapWindow       int
+	flapThreshold    int // 0 disables flap damping
+	links            map[string]*linkState
+}
+
+func NewTracker(failureThreshold, flapWindow, flapThreshold int) *Tracker {
+	return &Tracker{
+		failureThreshold: max(1, failureThreshold),
+		flapWindow:       max(2, flapWindow),
+		flapThreshold:    flapThreshold,
+		links:            make(map[string]*linkState),
+	}
+}
+
+// Update records the results of a run and returns the alerts it causes, sorted
+// by URL. Links the run didn't reach, was blocked from or skipped keep their state.
+func (t *Tracker) Update(results []linkpatrol.Result, now time.Time) []Alert {
+	var alerts []Alert
+	for _, result := range results {
+		if result.Status != linkpatrol.Live && !result.Status.IsFailure() {
+			continue
+		}
+		link := t.links[result.URL]
+		if link == nil {
+			link = &linkState{state: Unknown}
+			t.links[result.URL] = link
+		}
+
+		failed := result.Status.IsFailure()
+		link.history = append(link.history, failed)
+		if len(link.history) > t.flapWindow {
+			link.history = link.history[len(link.history)-t.flapWindow:]
+		}
+		if failed {
+			link.failures++
+		} else {
+			link.failures = 0
+		}
+
+		next := link.state
+		switch {
+		case t.isFlapping(link):
+			next = Flapping
+		case !failed:
+			next = Up
+		case link.failures >= t.failureThreshold:
+			next = Down
+		}
+		if next == link.state {
+			continue
+		}
+
+		previous := link.state
+		link.state = next
+		// A link that works from the start is the normal case, not news
+		if previous == Unknown && next == Up {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			URL:      result.URL,
+			Referrer: result.Referrer,
+			Previous: previous,
+			Current:  next,
+			Result:   result,
+			Failures: link.failures,
+			At:       now,
+		})
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].URL < alerts[j].URL
+	})
+	return alerts
+}
+
+// Counts returns how many tracked links are in each state
+func (t *Tracker) Counts() map[State]int {
+	counts := make(map[State]int)
+	for _, link := range t.links {
+		counts[link.state]++
+	}
+	return counts
+}
+
+// isFlapping reports whether the link's result changed often enough within its history
+func (t *Tracker) isFlapping(link *linkState) bool {
+	if t.flapThreshold <= 0 {
+		return false
+	}
+	changes := 0
+	for i := 1; i < len(link.history); i++ {
+		if link.history[i] != link.history[i-1] {
+			changes++
+		}
+	}
+	return changes >= t.flapThreshold
+}
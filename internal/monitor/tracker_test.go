@@ -0,0 +1,159 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+const link = "https://site.example/page"
+
+// states runs the tracker once per status, all for the same link, and returns
+// the state each run alerted about, or "" for runs without an alert
+func states(tracker *Tracker, statuses ...linkpatrol.Status) []State {
+	var got []State
+	for i, status := range statuses {
+		alerts := tracker.Update([]linkpatrol.Result{{URL: link, Status: status}}, time.Unix(int64(i), 0))
+		switch len(alerts) {
+		case 0:
+			got = append(got, "")
+		default:
+			got = append(got, alerts[0].Current)
+		}
+	}
+	return got
+}
+
+func equalStates(got, want []State) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	live = linkpatrol.Live
+	dead = linkpatrol.Dead
+)
+
+func TestTrackerTransitions(t *testing.T) {
+	tests := []struct {
+		name                    string
+		failures, window, flaps int
+		statuses                []linkpatrol.Status
+		want                    []State
+	}{
+		{
+			name:     "working from the start isn't news",
+			failures: 2, window: 10, flaps: 4,
+			statuses: []linkpatrol.Status{live, live},
+			want:     []State{"", ""},
+		},
+		{
+			name:     "down only after the failure threshold",
+			failures: 3, window: 10, flaps: 0,
+			statuses: []linkpatrol.Status{live, dead, dead, dead, dead},
+			want:     []State{"", "", "", Down, ""},
+		},
+		{
+			name:     "a failure below the threshold is forgotten by a working run",
+			failures: 2, window: 10, flaps: 0,
+			statuses: []linkpatrol.Status{live, dead, live, dead, live},
+			want:     []State{"", "", "", "", ""},
+		},
+		{
+			name:     "failing from the start",
+			failures: 2, window: 10, flaps: 0,
+			statuses: []linkpatrol.Status{dead, dead},
+			want:     []State{"", Down},
+		},
+		{
+			name:     "up after the first working run",
+			failures: 1, window: 10, flaps: 0,
+			statuses: []linkpatrol.Status{live, dead, live},
+			want:     []State{"", Down, Up},
+		},
+		{
+			name:     "without flap damping every change alerts",
+			failures: 1, window: 10, flaps: 0,
+			statuses: []linkpatrol.Status{live, dead, live, dead, live, dead},
+			want:     []State{"", Down, Up, Down, Up, Down},
+		},
+		{
+			name:     "flapping alerts once and holds the rest",
+			failures: 1, window: 10, flaps: 4,
+			statuses: []linkpatrol.Status{live, dead, live, dead, live, dead, live, dead},
+			want:     []State{"", Down, Up, Down, Flapping, "", "", ""},
+		},
+		{
+			name:     "flapping settles once its changes leave the window",
+			failures: 1, window: 4, flaps: 3,
+			// Run 5's window, dead live dead dead, only holds 2 changes
+			statuses: []linkpatrol.Status{live, dead, live, dead, dead, dead},
+			want:     []State{"", Down, Up, Flapping, Down, ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(tt.failures, tt.window, tt.flaps)
+			if got := states(tracker, tt.statuses...); !equalStates(got, tt.want) {
+				t.Errorf("alerts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerKeepsStateForUncheckedLinks(t *testing.T) {
+	tracker := NewTracker(1, 10, 0)
+	states(tracker, live, dead)
+
+	// Blocked, ignored and missing links say nothing about whether the link works
+	if got := states(tracker, linkpatrol.Bot, linkpatrol.Ignore); !equalStates(got, []State{"", ""}) {
+		t.Errorf("alerts = %q", got)
+	}
+	tracker.Update(nil, time.Now())
+	if counts := tracker.Counts(); counts[Down] != 1 {
+		t.Errorf("counts = %v, want the link still down", counts)
+	}
+	if got := states(tracker, live); !equalStates(got, []State{Up}) {
+		t.Errorf("alerts = %q, want the recovery", got)
+	}
+}
+
+func TestTrackerAlertDetails(t *testing.T) {
+	tracker := NewTracker(2, 10, 0)
+	now := time.Now()
+	result := linkpatrol.Result{URL: link, Status: dead, Error: "HTTP 404", Referrer: "https://site.example/"}
+	tracker.Update([]linkpatrol.Result{result}, now)
+	alerts := tracker.Update([]linkpatrol.Result{
+		result,
+		{URL: "https://site.example/a", Status: dead},
+	}, now)
+
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %+v, want just the link that failed twice", alerts)
+	}
+	alert := alerts[0]
+	if alert.URL != link || alert.Referrer != result.Referrer || alert.Previous != Unknown || alert.Current != Down ||
+		alert.Failures != 2 || alert.Result.Error != result.Error || !alert.At.Equal(now) {
+		t.Errorf("alert = %+v", alert)
+	}
+}
+
+func TestTrackerSortsAlerts(t *testing.T) {
+	tracker := NewTracker(1, 10, 0)
+	alerts := tracker.Update([]linkpatrol.Result{
+		{URL: "https://site.example/c", Status: dead},
+		{URL: "https://site.example/a", Status: dead},
+		{URL: "https://site.example/b", Status: dead},
+	}, time.Now())
+	if len(alerts) != 3 || alerts[0].URL > alerts[1].URL || alerts[1].URL > alerts[2].URL {
+		t.Errorf("alerts = %+v, want them sorted by URL", alerts)
+	}
+}
@@ -0,0 +1,92 @@
+package profiles
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeCookies(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// cookieNames lists the names of the cookies the jar sends to rawURL, sorted
+func cookieNames(t *testing.T, jar http.CookieJar, rawURL string) []string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cookie := range jar.Cookies(u) {
+		names = append(names, cookie.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestLoadCookieJar(t *testing.T) {
+	path := writeCookies(t,
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tFALSE\t0\tshared\t1",
+		"example.com\tFALSE\t/\tFALSE\t0\thost_only\t1",
+		"#HttpOnly_example.com\tFALSE\t/\tTRUE\t4102444800\tsession\tabc",
+		"example.com\tFALSE\t/\tFALSE\t946684800\texpired\t1",
+		"example.com\tFALSE\t/admin\tFALSE\t0\tadmin\t1",
+	)
+	jar, err := LoadCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://example.com/", []string{"host_only", "session", "shared"}},
+		{"http://example.com/", []string{"host_only", "shared"}}, // session is secure
+		{"https://example.com/admin/users", []string{"admin", "host_only", "session", "shared"}},
+		{"https://docs.example.com/", []string{"shared"}},
+		{"https://other.example/", nil},
+	}
+	for _, tt := range tests {
+		if got := cookieNames(t, jar, tt.url); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sent %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCookieJarRejectsMalformedLines(t *testing.T) {
+	path := writeCookies(t,
+		"# Netscape HTTP Cookie File",
+		"example.com\tFALSE\t/\tFALSE\t0\tok\t1",
+		"example.com FALSE / FALSE 0 spaces 1",
+	)
+	_, err := LoadCookieJar(path)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("err = %v, want one naming line 3", err)
+	}
+}
+
+func TestLoadCookieJarWithoutAFile(t *testing.T) {
+	jar, err := LoadCookieJar("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jar == nil {
+		t.Fatal("no jar")
+	}
+	if _, err := LoadCookieJar(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
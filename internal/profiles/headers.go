@@ -0,0 +1,197 @@
+package profiles
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+// DefaultContactURL is where site admins can find out about LinkPatrol
+const DefaultContactURL = "https://github.com/sirprodigle/linkpatrol"
+
+// HeaderProfile is a named set of headers that decides how requests present
+// themselves to servers, chiefly through the User-Agent
+type HeaderProfile struct {
+	Name    string
+	Headers http.Header
+}
+
+// BuiltinHeaderProfiles returns the profiles that are always available. The
+// linkpatrol profile identifies the crawler honestly and links to contactURL,
+// the others mimic desktop browsers for sites that turn crawlers away.
+func BuiltinHeaderProfiles(contactURL string) map[string]HeaderProfile {
+	return map[string]HeaderProfile{
+		"linkpatrol": {
+			Name: "linkpatrol",
+			Headers: http.Header{
+				"User-Agent": {"Mozilla/5.0 (compatible; LinkPatrol; +" + contactURL + ")"},
+				"Accept":     {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			},
+		},
+		"chrome": {
+			Name: "chrome",
+			Headers: http.Header{
+				"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"},
+				"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8"},
+				"Accept-Language":           {"en-US,en;q=0.5"},
+				"Upgrade-Insecure-Requests": {"1"},
+			},
+		},
+		"firefox": {
+			Name: "firefox",
+			Headers: http.Header{
+				"User-Agent":                {"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"},
+				"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+				"Accept-Language":           {"en-US,en;q=0.5"},
+				"Upgrade-Insecure-Requests": {"1"},
+			},
+		},
+	}
+}
+
+// HeaderProfiles picks the header profile for each request by host
+type HeaderProfiles struct {
+	profiles  map[string]HeaderProfile
+	fallback  HeaderProfile
+	overrides []hostOverride
+	retry     *HeaderProfile // sent again when a host turns the first profile away, if set
+}
+
+type hostOverride struct {
+	hosts   []string
+	profile HeaderProfile
+}
+
+// NewHeaderProfiles builds the profile set from the builtin profiles plus
+// custom, which may replace them. fallback is used for hosts whose host
+// profile doesn't name a header profile, and retry, if not empty, is tried
+// when a host answers like it blocks bots.
+func NewHeaderProfiles(contactURL string, custom map[string]map[string]string, fallback string, hostProfiles []config.HostProfile, retry string) (*HeaderProfiles, error) {
+	profiles := BuiltinHeaderProfiles(contactURL)
+	for name, headers := range custom {
+		name = strings.ToLower(name)
+		p := HeaderProfile{Name: name, Headers: make(http.Header, len(headers))}
+		for key, value := range headers {
+			p.Headers.Set(key, value)
+		}
+		profiles[name] = p
+	}
+
+	lookup := func(name string) (HeaderProfile, error) {
+		p, ok := profiles[strings.ToLower(name)]
+		if !ok {
+			return HeaderProfile{}, fmt.Errorf("unknown header profile %q", name)
+		}
+		return p, nil
+	}
+
+	hp := &HeaderProfiles{profiles: profiles}
+	var err error
+	if hp.fallback, err = lookup(fallback); err != nil {
+		return nil, err
+	}
+	if retry != "" {
+		p, err := lookup(retry)
+		if err != nil {
+			return nil, err
+		}
+		hp.retry = &p
+	}
+
+	for _, hostProfile := range hostProfiles {
+		if hostProfile.HeaderProfile == "" {
+			continue
+		}
+		p, err := lookup(hostProfile.HeaderProfile)
+		if err != nil {
+			return nil, fmt.Errorf("host profile %s: %w", strings.Join(hostProfile.Hosts, ", "), err)
+		}
+		hp.overrides = append(hp.overrides, hostOverride{hosts: hostProfile.Hosts, profile: p})
+	}
+	return hp, nil
+}
+
+// ForHost returns the profile requests to host are sent with
+func (hp *HeaderProfiles) ForHost(host string) HeaderProfile {
+	for _, o := range hp.overrides {
+		if matchHost(o.hosts, host) {
+			return o.profile
+		}
+	}
+	return hp.fallback
+}
+
+// HeaderTransport adds a header profile's headers to each request that
+// doesn't already set them. When a host turns a request away with a
+// bot-blocking status, the request is sent again with the retry profile, and
+// if that gets through the host keeps using it for the rest of the run.
+type HeaderTransport struct {
+	base     http.RoundTripper
+	profiles *HeaderProfiles
+
+	mu       sync.Mutex
+	switched map[string]bool // hosts that only let the retry profile in
+}
+
+func NewHeaderTransport(base http.RoundTripper, profiles *HeaderProfiles) *HeaderTransport {
+	return &HeaderTransport{
+		base:     base,
+		profiles: profiles,
+		switched: make(map[string]bool, 100),
+	}
+}
+
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	profile := t.profiles.ForHost(host)
+	retry := t.profiles.retry
+
+	t.mu.Lock()
+	switched := t.switched[host]
+	t.mu.Unlock()
+	if switched {
+		profile, retry = *retry, nil
+	}
+
+	resp, err := t.base.RoundTrip(withProfile(req, profile))
+	// Only requests without a body can be sent again safely
+	if err != nil || retry == nil || retry.Name == profile.Name || req.Body != nil && req.Body != http.NoBody || !isBlockedStatus(resp.StatusCode) {
+		return resp, err
+	}
+
+	retryResp, retryErr := t.base.RoundTrip(withProfile(req, *retry))
+	if retryErr != nil {
+		return resp, nil
+	}
+	if isBlockedStatus(retryResp.StatusCode) {
+		retryResp.Body.Close()
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	t.mu.Lock()
+	t.switched[host] = true
+	t.mu.Unlock()
+	return retryResp, nil
+}
+
+// withProfile returns a copy of req with the profile's headers filled in
+func withProfile(req *http.Request, profile HeaderProfile) *http.Request {
+	req = req.Clone(req.Context())
+	for name, values := range profile.Headers {
+		if _, set := req.Header[name]; !set {
+			req.Header[name] = values
+		}
+	}
+	return req
+}
+
+// isBlockedStatus reports whether a status code is one sites commonly use to turn crawlers away
+func isBlockedStatus(code int) bool {
+	return code == http.StatusForbidden || code == http.StatusTooManyRequests || code == 999
+}
@@ -0,0 +1,158 @@
+package profiles
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+// gatekeeper turns away requests from crawlers on blocked hosts and records
+// the headers of every request it's sent
+type gatekeeper struct {
+	blocked map[string]bool
+
+	mu   sync.Mutex
+	sent []http.Header
+}
+
+func (g *gatekeeper) RoundTrip(req *http.Request) (*http.Response, error) {
+	g.mu.Lock()
+	g.sent = append(g.sent, req.Header.Clone())
+	g.mu.Unlock()
+	status := http.StatusOK
+	if g.blocked[req.URL.Host] && strings.Contains(req.Header.Get("User-Agent"), "LinkPatrol") {
+		status = http.StatusForbidden
+	}
+	return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+}
+
+// userAgents returns the User-Agent of each request sent since the last call
+func (g *gatekeeper) userAgents() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var agents []string
+	for _, header := range g.sent {
+		agents = append(agents, header.Get("User-Agent"))
+	}
+	g.sent = nil
+	return agents
+}
+
+func newHeaderTransport(t *testing.T, base http.RoundTripper, custom map[string]map[string]string, hostProfiles []config.HostProfile, retry string) *HeaderTransport {
+	t.Helper()
+	headerProfiles, err := NewHeaderProfiles(DefaultContactURL, custom, "linkpatrol", hostProfiles, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHeaderTransport(base, headerProfiles)
+}
+
+func get(t *testing.T, transport http.RoundTripper, rawURL string, header http.Header) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHeaderTransportRetriesBlockedRequests(t *testing.T) {
+	builtin := BuiltinHeaderProfiles(DefaultContactURL)
+	crawler, chrome := builtin["linkpatrol"].Headers.Get("User-Agent"), builtin["chrome"].Headers.Get("User-Agent")
+	base := &gatekeeper{blocked: map[string]bool{"shop.example": true}}
+	transport := newHeaderTransport(t, base, nil, nil, "chrome")
+
+	if status := get(t, transport, "https://docs.example/", nil); status != http.StatusOK {
+		t.Fatalf("docs.example status = %d", status)
+	}
+	if agents := base.userAgents(); len(agents) != 1 || agents[0] != crawler {
+		t.Errorf("docs.example was sent %q, want only the crawler's User-Agent", agents)
+	}
+
+	if status := get(t, transport, "https://shop.example/", nil); status != http.StatusOK {
+		t.Fatalf("shop.example status = %d, want the retry's 200", status)
+	}
+	if agents := base.userAgents(); len(agents) != 2 || agents[0] != crawler || agents[1] != chrome {
+		t.Errorf("shop.example was sent %q, want the crawler's then Chrome's User-Agent", agents)
+	}
+
+	// Once the retry got through, the host gets it straight away
+	get(t, transport, "https://shop.example/cart", nil)
+	if agents := base.userAgents(); len(agents) != 1 || agents[0] != chrome {
+		t.Errorf("shop.example was then sent %q, want only Chrome's User-Agent", agents)
+	}
+	get(t, transport, "https://docs.example/guide", nil)
+	if agents := base.userAgents(); len(agents) != 1 || agents[0] != crawler {
+		t.Errorf("docs.example was then sent %q, want only the crawler's User-Agent", agents)
+	}
+}
+
+func TestHeaderTransportWithoutRetry(t *testing.T) {
+	base := &gatekeeper{blocked: map[string]bool{"shop.example": true}}
+	transport := newHeaderTransport(t, base, nil, nil, "")
+
+	if status := get(t, transport, "https://shop.example/", nil); status != http.StatusForbidden {
+		t.Errorf("status = %d, want the 403", status)
+	}
+	if agents := base.userAgents(); len(agents) != 1 {
+		t.Errorf("sent %d requests, want 1", len(agents))
+	}
+}
+
+func TestHeaderTransportUsesTheHostsProfile(t *testing.T) {
+	base := &gatekeeper{}
+	transport := newHeaderTransport(t, base,
+		map[string]map[string]string{"internal": {"User-Agent": "docs-bot", "X-Team": "web"}},
+		[]config.HostProfile{
+			{Hosts: []string{"*.corp.example"}, HeaderProfile: "internal"},
+			{Hosts: []string{"legacy.example"}, HeaderProfile: "Firefox"},
+		},
+		"chrome",
+	)
+	firefox := BuiltinHeaderProfiles(DefaultContactURL)["firefox"].Headers.Get("User-Agent")
+
+	get(t, transport, "https://wiki.corp.example/", nil)
+	get(t, transport, "https://legacy.example/", nil)
+	if agents := base.userAgents(); len(agents) != 2 || agents[0] != "docs-bot" || agents[1] != firefox {
+		t.Errorf("sent %q, want the internal then the Firefox User-Agent", agents)
+	}
+}
+
+func TestHeaderTransportKeepsTheRequestsOwnHeaders(t *testing.T) {
+	base := &gatekeeper{}
+	transport := newHeaderTransport(t, base, nil, nil, "")
+	profile := BuiltinHeaderProfiles(DefaultContactURL)["linkpatrol"]
+
+	get(t, transport, "https://api.example/", http.Header{"Accept": {"application/json"}})
+	sent := base.sent[0]
+	if got := sent.Get("Accept"); got != "application/json" {
+		t.Errorf("Accept = %q, want the request's own", got)
+	}
+	if got := sent.Get("User-Agent"); got != profile.Headers.Get("User-Agent") {
+		t.Errorf("User-Agent = %q, want the profile's", got)
+	}
+}
+
+func TestNewHeaderProfilesRejectsUnknownProfiles(t *testing.T) {
+	if _, err := NewHeaderProfiles(DefaultContactURL, nil, "netscape", nil, ""); err == nil {
+		t.Error("accepted an unknown fallback profile")
+	}
+	if _, err := NewHeaderProfiles(DefaultContactURL, nil, "linkpatrol", nil, "netscape"); err == nil {
+		t.Error("accepted an unknown retry profile")
+	}
+	hostProfiles := []config.HostProfile{{Hosts: []string{"legacy.example"}, HeaderProfile: "netscape"}}
+	if _, err := NewHeaderProfiles(DefaultContactURL, nil, "linkpatrol", hostProfiles, ""); err == nil {
+		t.Error("accepted an unknown host profile")
+	}
+}
@@ -0,0 +1,195 @@
+package profiles
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+// loginMaxBytes caps how much of a login page or its response is read
+const loginMaxBytes = 2 << 20
+
+// loginForm is a form found on the login page
+type loginForm struct {
+	action string
+	method string
+	fields url.Values
+}
+
+// Login signs in through the login form described by cfg so the session
+// cookies end up in client's jar. It fetches the login page, keeps the form's
+// hidden fields (such as CSRF tokens), posts the credentials and checks the
+// response looks like a signed-in page.
+func Login(ctx context.Context, client *http.Client, cfg config.LoginConfig) error {
+	if client.Jar == nil {
+		return fmt.Errorf("login needs a cookie jar to keep the session")
+	}
+	username, err := lookupLoginEnv(cfg.UsernameEnv)
+	if err != nil {
+		return err
+	}
+	password, err := lookupLoginEnv(cfg.PasswordEnv)
+	if err != nil {
+		return err
+	}
+	usernameField := cmp.Or(cfg.UsernameField, "username")
+	passwordField := cmp.Or(cfg.PasswordField, "password")
+
+	// Fetch the login page to pick up the form and any cookies it sets
+	pageURL, body, _, err := loginRequest(ctx, client, "GET", cfg.URL, nil)
+	if err != nil {
+		return fmt.Errorf("fetching login page: %w", err)
+	}
+	form, ok := findLoginForm(body, passwordField)
+	if !ok {
+		return fmt.Errorf("no form with a %q field on login page %s", passwordField, cfg.URL)
+	}
+
+	action, err := pageURL.Parse(form.action)
+	if err != nil {
+		return fmt.Errorf("login form action %q: %w", form.action, err)
+	}
+	form.fields.Set(usernameField, username)
+	form.fields.Set(passwordField, password)
+	for name, value := range cfg.Fields {
+		form.fields.Set(name, value)
+	}
+
+	finalURL, body, status, err := loginRequest(ctx, client, form.method, action.String(), form.fields)
+	if err != nil {
+		return fmt.Errorf("submitting login form: %w", err)
+	}
+
+	// Check the response is the signed-in site rather than the form again
+	switch {
+	case status >= 400:
+		return fmt.Errorf("%s answered %d", finalURL, status)
+	case cfg.SuccessURL != "" && !strings.Contains(finalURL.String(), cfg.SuccessURL):
+		return fmt.Errorf("ended up on %s, expected a URL containing %q", finalURL, cfg.SuccessURL)
+	case cfg.SuccessText != "" && !bytes.Contains(body, []byte(cfg.SuccessText)):
+		return fmt.Errorf("response from %s doesn't contain %q", finalURL, cfg.SuccessText)
+	case cfg.SuccessURL == "" && cfg.SuccessText == "":
+		if _, stillLogin := findLoginForm(body, passwordField); stillLogin {
+			return fmt.Errorf("%s still shows the login form", finalURL)
+		}
+	}
+	return nil
+}
+
+// loginRequest sends a login request, following redirects, and returns where
+// it ended up along with the response body and status
+func loginRequest(ctx context.Context, client *http.Client, method, target string, form url.Values) (*url.URL, []byte, int, error) {
+	var body io.Reader
+	if method == "POST" {
+		body = strings.NewReader(form.Encode())
+	} else if form != nil {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		u.RawQuery = form.Encode()
+		target = u.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, loginMaxBytes))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return resp.Request.URL, respBody, resp.StatusCode, nil
+}
+
+// findLoginForm returns the first form on the page with a field named
+// passwordField, along with the values its inputs would submit
+func findLoginForm(body []byte, passwordField string) (loginForm, bool) {
+	var current *loginForm
+	hasPassword := false
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// Tolerate a form that is never closed
+			if current != nil && hasPassword {
+				return *current, true
+			}
+			return loginForm{}, false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "form":
+				current = &loginForm{
+					action: attrs["action"],
+					method: strings.ToUpper(cmp.Or(attrs["method"], "GET")),
+					fields: url.Values{},
+				}
+				hasPassword = false
+			case "input":
+				if current == nil || attrs["name"] == "" {
+					continue
+				}
+				if attrs["name"] == passwordField {
+					hasPassword = true
+				}
+				switch strings.ToLower(attrs["type"]) {
+				case "submit", "button", "image", "reset", "file":
+					continue
+				case "checkbox", "radio":
+					if _, checked := attrs["checked"]; !checked {
+						continue
+					}
+				}
+				current.fields.Set(attrs["name"], attrs["value"])
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "form" && current != nil {
+				if hasPassword {
+					return *current, true
+				}
+				current = nil
+			}
+		}
+	}
+}
+
+func lookupLoginEnv(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("login needs both username-env and password-env")
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s for login is not set", name)
+	}
+	return value, nil
+}
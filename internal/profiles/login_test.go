@@ -0,0 +1,174 @@
+package profiles
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+const loginPage = `<form action="/search"><input name="q"></form>
+<form method="post" action="session">
+  <input type="hidden" name="csrf" value="token-123">
+  <input name="username">
+  <input type="password" name="password">
+  <input type="checkbox" name="remember" value="yes" checked>
+  <input type="checkbox" name="newsletter" value="yes">
+  <input type="radio" name="plan" value="free">
+  <input type="radio" name="plan" value="pro" checked>
+  <input type="submit" name="go" value="Sign in">
+</form>`
+
+// loginSite serves a login form at /account/login that posts to
+// /account/session, which signs in with the user "ci" and the password
+// "s3cret" and redirects to /dashboard. posted receives every submission.
+func loginSite(t *testing.T, posted chan<- url.Values) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /account/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "token-123"})
+		fmt.Fprint(w, loginPage)
+	})
+	mux.HandleFunc("POST /account/session", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		posted <- r.PostForm
+		cookie, err := r.Cookie("csrf")
+		if err != nil || cookie.Value != r.PostForm.Get("csrf") || r.PostForm.Get("username") != "ci" || r.PostForm.Get("password") != "s3cret" {
+			fmt.Fprint(w, loginPage)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "signed-in", Path: "/"})
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	})
+	mux.HandleFunc("GET /dashboard", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<h1>Welcome back</h1><a href="/logout">Sign out</a>`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func loginClient(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+func TestLoginSubmitsTheForm(t *testing.T) {
+	t.Setenv("LOGIN_USER", "ci")
+	t.Setenv("LOGIN_PASSWORD", "s3cret")
+	posted := make(chan url.Values, 1)
+	server := loginSite(t, posted)
+	client := loginClient(t)
+
+	err := Login(context.Background(), client, config.LoginConfig{
+		URL:         server.URL + "/account/login",
+		UsernameEnv: "LOGIN_USER",
+		PasswordEnv: "LOGIN_PASSWORD",
+		Fields:      map[string]string{"tenant": "docs"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The form's action, "session", is resolved against /account/login
+	var form url.Values
+	select {
+	case form = <-posted:
+	default:
+		t.Fatal("the form wasn't posted to /account/session")
+	}
+	want := url.Values{
+		"csrf":     {"token-123"}, // hidden fields are carried over
+		"username": {"ci"},
+		"password": {"s3cret"},
+		"remember": {"yes"}, // only checked boxes and radios are sent
+		"plan":     {"pro"},
+		"tenant":   {"docs"},
+	}
+	if form.Encode() != want.Encode() {
+		t.Errorf("posted %s, want %s", form.Encode(), want.Encode())
+	}
+
+	site, _ := url.Parse(server.URL)
+	cookies := client.Jar.Cookies(site)
+	if !strings.Contains(fmt.Sprint(cookies), "session=signed-in") {
+		t.Errorf("cookies = %v, want the session", cookies)
+	}
+}
+
+func TestLoginChecksTheResponse(t *testing.T) {
+	t.Setenv("LOGIN_USER", "ci")
+	t.Setenv("LOGIN_PASSWORD", "s3cret")
+	t.Setenv("WRONG_PASSWORD", "guess")
+
+	tests := []struct {
+		name        string
+		passwordEnv string
+		successURL  string
+		successText string
+		wantErr     string
+	}{
+		{name: "no login form in the response", passwordEnv: "LOGIN_PASSWORD"},
+		{name: "success-url matches", passwordEnv: "LOGIN_PASSWORD", successURL: "/dashboard"},
+		{name: "success-url doesn't match", passwordEnv: "LOGIN_PASSWORD", successURL: "/home", wantErr: `expected a URL containing "/home"`},
+		{name: "success-text matches", passwordEnv: "LOGIN_PASSWORD", successText: "Welcome back"},
+		{name: "success-text doesn't match", passwordEnv: "LOGIN_PASSWORD", successText: "Hello admin", wantErr: `doesn't contain "Hello admin"`},
+		{name: "wrong password", passwordEnv: "WRONG_PASSWORD", wantErr: "still shows the login form"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := loginSite(t, make(chan url.Values, 1))
+			err := Login(context.Background(), loginClient(t), config.LoginConfig{
+				URL:         server.URL + "/account/login",
+				UsernameEnv: "LOGIN_USER",
+				PasswordEnv: tt.passwordEnv,
+				SuccessURL:  tt.successURL,
+				SuccessText: tt.successText,
+			})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("err = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("err = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFindLoginForm(t *testing.T) {
+	form, ok := findLoginForm([]byte(loginPage), "password")
+	if !ok {
+		t.Fatal("login form not found")
+	}
+	if form.action != "session" || form.method != "POST" {
+		t.Errorf("form = %+v", form)
+	}
+	if _, ok := form.fields["go"]; ok {
+		t.Error("the submit button's value would be sent")
+	}
+
+	// A form that's never closed still counts, and a GET form is the default
+	form, ok = findLoginForm([]byte(`<form action="/in"><input name="pin" type="password">`), "pin")
+	if !ok || form.action != "/in" || form.method != "GET" {
+		t.Errorf("unclosed form = %+v, %v", form, ok)
+	}
+	if _, ok := findLoginForm([]byte(`<form><input name="q"></form>`), "password"); ok {
+		t.Error("found a login form without a password field")
+	}
+}
+
+func TestLoginNeedsACookieJar(t *testing.T) {
+	if err := Login(context.Background(), &http.Client{}, config.LoginConfig{URL: "https://site.example/login"}); err == nil {
+		t.Error("logged in without a jar to keep the session in")
+	}
+}
@@ -0,0 +1,105 @@
+package profiles
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/envvar"
+)
+
+// proxyRoute sends requests for the matching hosts through proxy, or directly when proxy is nil
+type proxyRoute struct {
+	hosts []string
+	proxy *url.URL
+}
+
+// NewProxyFunc returns the proxy selector for the HTTP transport. Requests
+// are routed by the first matching route, then hosts in directHosts (the
+// target site) are reached directly, and everything else goes through
+// defaultProxy. Without a defaultProxy, HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// from the environment decide. Proxy URLs may be http, https, socks5 or
+// socks5h, and environment variables written as ${NAME} in them are
+// expanded so credentials can be kept out of the config.
+func NewProxyFunc(defaultProxy string, routes []config.ProxyRoute, directHosts []string) (func(*http.Request) (*url.URL, error), error) {
+	compiled := make([]proxyRoute, 0, len(routes))
+	for _, route := range routes {
+		if len(route.Hosts) == 0 {
+			return nil, fmt.Errorf("proxy route %q has no hosts", route.Proxy)
+		}
+		var proxy *url.URL
+		if route.Proxy != "" && route.Proxy != "direct" {
+			var err error
+			if proxy, err = parseProxyURL(route.Proxy); err != nil {
+				return nil, err
+			}
+		}
+		compiled = append(compiled, proxyRoute{hosts: route.Hosts, proxy: proxy})
+	}
+
+	// httpproxy honours NO_PROXY and never proxies localhost, for explicit and environment proxies alike
+	env := httpproxy.FromEnvironment()
+	if defaultProxy != "" {
+		proxy, err := parseProxyURL(defaultProxy)
+		if err != nil {
+			return nil, err
+		}
+		env.HTTPProxy = proxy.String()
+		env.HTTPSProxy = proxy.String()
+	}
+	fromConfig := env.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Host)
+		for _, route := range compiled {
+			if matchHost(route.hosts, host) {
+				return route.proxy, nil
+			}
+		}
+		if matchHost(directHosts, host) {
+			return nil, nil
+		}
+		return fromConfig(req.URL)
+	}, nil
+}
+
+// UsesProxy reports whether any request may go through a proxy, either one
+// configured or one from HTTP_PROXY/HTTPS_PROXY
+func UsesProxy(defaultProxy string, routes []config.ProxyRoute) bool {
+	if defaultProxy != "" {
+		return true
+	}
+	for _, route := range routes {
+		if route.Proxy != "" && route.Proxy != "direct" {
+			return true
+		}
+	}
+	env := httpproxy.FromEnvironment()
+	return env.HTTPProxy != "" || env.HTTPSProxy != ""
+}
+
+// parseProxyURL expands ${NAME} variables in a proxy URL and checks it's one the transport can use
+func parseProxyURL(raw string) (*url.URL, error) {
+	expanded, err := envvar.Expand(raw)
+	if err != nil {
+		return nil, fmt.Errorf("proxy URL %q: %w", raw, err)
+	}
+	proxy, err := url.Parse(expanded)
+	if err != nil {
+		// The parse error would include the expanded credentials
+		return nil, fmt.Errorf("invalid proxy URL %q", raw)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy URL %q must use http, https, socks5 or socks5h", raw)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return proxy, nil
+}
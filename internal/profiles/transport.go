@@ -0,0 +1,130 @@
+package profiles
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+// Profile is a host profile from the config with its credentials read from the environment
+type Profile struct {
+	Hosts    []string
+	Headers  http.Header
+	Username string
+	Password string
+	Bearer   string
+}
+
+// Resolve reads the credentials referenced by each host profile from the
+// environment, failing if a referenced variable isn't set
+func Resolve(hostProfiles []config.HostProfile) ([]Profile, error) {
+	resolved := make([]Profile, 0, len(hostProfiles))
+	for i, hp := range hostProfiles {
+		if len(hp.Hosts) == 0 {
+			return nil, fmt.Errorf("host profile %d has no hosts", i+1)
+		}
+
+		p := Profile{
+			Hosts:   hp.Hosts,
+			Headers: make(http.Header, len(hp.Headers)),
+		}
+		for name, value := range hp.Headers {
+			p.Headers.Set(name, value)
+		}
+
+		var err error
+		if hp.UsernameEnv != "" || hp.PasswordEnv != "" {
+			if p.Username, err = lookupEnv(hp.UsernameEnv, hp.Hosts); err != nil {
+				return nil, err
+			}
+			if p.Password, err = lookupEnv(hp.PasswordEnv, hp.Hosts); err != nil {
+				return nil, err
+			}
+		}
+		if hp.BearerTokenEnv != "" {
+			if p.Bearer, err = lookupEnv(hp.BearerTokenEnv, hp.Hosts); err != nil {
+				return nil, err
+			}
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+func lookupEnv(name string, hosts []string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("host profile for %s sets only one of username-env and password-env", strings.Join(hosts, ", "))
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s for host profile %s is not set", name, strings.Join(hosts, ", "))
+	}
+	return value, nil
+}
+
+// Matches reports whether the profile applies to host
+func (p Profile) Matches(host string) bool {
+	return matchHost(p.Hosts, host)
+}
+
+// matchHost reports whether host matches one of patterns. Patterns match the
+// host with or without its port, and "*.example.com" matches any subdomain.
+func matchHost(patterns []string, host string) bool {
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.Contains(host[i:], "]") {
+		hostname = host[:i]
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(hostname, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if pattern == host || pattern == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// Transport applies the first matching host profile to every request before
+// handing it to the wrapped transport
+type Transport struct {
+	base     http.RoundTripper
+	profiles []Profile
+}
+
+func NewTransport(base http.RoundTripper, profiles []Profile) *Transport {
+	return &Transport{
+		base:     base,
+		profiles: profiles,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Host)
+	for _, p := range t.profiles {
+		if !p.Matches(host) {
+			continue
+		}
+
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		for name, values := range p.Headers {
+			req.Header[name] = values
+		}
+		switch {
+		case p.Bearer != "":
+			req.Header.Set("Authorization", "Bearer "+p.Bearer)
+		case p.Username != "":
+			req.SetBasicAuth(p.Username, p.Password)
+		}
+		break
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,94 @@
+package profiles
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+// recorder answers every request with 200 and keeps the last one
+type recorder struct {
+	last *http.Request
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.last = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("DOCS_USER", "ci")
+	t.Setenv("DOCS_PASSWORD", "s3cret")
+	t.Setenv("API_TOKEN", "t0ken")
+
+	profiles, err := Resolve([]config.HostProfile{
+		{Hosts: []string{"docs.example.com"}, Headers: map[string]string{"x-team": "web"}, UsernameEnv: "DOCS_USER", PasswordEnv: "DOCS_PASSWORD"},
+		{Hosts: []string{"*.internal.example"}, BearerTokenEnv: "API_TOKEN"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := profiles[0]; p.Username != "ci" || p.Password != "s3cret" || p.Headers.Get("X-Team") != "web" {
+		t.Errorf("profile 1 = %+v", p)
+	}
+	if p := profiles[1]; p.Bearer != "t0ken" || p.Username != "" {
+		t.Errorf("profile 2 = %+v", p)
+	}
+}
+
+func TestResolveRejectsIncompleteProfiles(t *testing.T) {
+	t.Setenv("DOCS_USER", "ci")
+	for name, hp := range map[string]config.HostProfile{
+		"no hosts":           {UsernameEnv: "DOCS_USER", PasswordEnv: "DOCS_USER"},
+		"username only":      {Hosts: []string{"docs.example.com"}, UsernameEnv: "DOCS_USER"},
+		"unset password":     {Hosts: []string{"docs.example.com"}, UsernameEnv: "DOCS_USER", PasswordEnv: "UNSET_PASSWORD"},
+		"unset bearer token": {Hosts: []string{"docs.example.com"}, BearerTokenEnv: "UNSET_TOKEN"},
+	} {
+		if _, err := Resolve([]config.HostProfile{hp}); err == nil {
+			t.Errorf("%s: resolved", name)
+		}
+	}
+}
+
+func TestTransportAppliesTheMatchingProfile(t *testing.T) {
+	profiles := []Profile{
+		{Hosts: []string{"docs.example.com"}, Headers: http.Header{"X-Team": {"web"}}, Username: "ci", Password: "s3cret"},
+		{Hosts: []string{"*.internal.example"}, Bearer: "t0ken"},
+		{Hosts: []string{"docs.example.com"}, Bearer: "never-used"}, // the first match wins
+	}
+	rec := &recorder{}
+	client := &http.Client{Transport: NewTransport(rec, profiles)}
+
+	tests := []struct {
+		url           string
+		team          string
+		authorization string
+	}{
+		{"https://docs.example.com/guide", "web", "Basic Y2k6czNjcmV0"},
+		{"https://docs.example.com:8443/guide", "web", "Basic Y2k6czNjcmV0"},
+		{"https://api.internal.example/v1", "", "Bearer t0ken"},
+		{"https://internal.example/", "", ""}, // *. only matches subdomains
+		{"https://other.example/", "", ""},
+		{"https://docs.example.com.evil.example/", "", ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		sent := rec.last.Header
+		if sent.Get("X-Team") != tt.team || sent.Get("Authorization") != tt.authorization {
+			t.Errorf("%s: sent X-Team %q and Authorization %q, want %q and %q", tt.url, sent.Get("X-Team"), sent.Get("Authorization"), tt.team, tt.authorization)
+		}
+		if len(req.Header) != 0 {
+			t.Errorf("%s: the caller's request was changed: %v", tt.url, req.Header)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+var csvHeader = []string{"url", "status", "failure", "error", "referrer", "domain", "redirects"}
+
+// WriteCSV writes one row per result, failures first, for loading into a spreadsheet
+func WriteCSV(w io.Writer, report *linkpatrol.Report) error {
+	results := append([]linkpatrol.Result(nil), report.Results...)
+	sort.Slice(results, func(i, j int) bool {
+		if rank(results[i].Status) != rank(results[j].Status) {
+			return rank(results[i].Status) < rank(results[j].Status)
+		}
+		return results[i].URL < results[j].URL
+	})
+
+	out := csv.NewWriter(w)
+	out.Write(csvHeader)
+	for _, result := range results {
+		hops := make([]string, 0, len(result.Redirects))
+		for _, hop := range result.Redirects {
+			hops = append(hops, fmt.Sprintf("%d %s", hop.StatusCode, hop.URL))
+		}
+		out.Write([]string{
+			spreadsheetSafe(result.URL),
+			result.Status.String(),
+			fmt.Sprint(result.Status.IsFailure()),
+			spreadsheetSafe(result.Error),
+			spreadsheetSafe(result.Referrer),
+			domainOf(result.URL),
+			spreadsheetSafe(strings.Join(hops, " -> ")),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// spreadsheetSafe stops a value taken from a crawled page being run as a
+// formula when the file is opened in a spreadsheet
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
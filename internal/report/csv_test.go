@@ -0,0 +1,55 @@
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestSpreadsheetSafe(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"=HYPERLINK(\"https://evil.example\")", "'=HYPERLINK(\"https://evil.example\")"},
+		{"+1", "'+1"},
+		{"-1+cmd", "'-1+cmd"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"https://site.example/a=b", "https://site.example/a=b"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := spreadsheetSafe(tt.value); got != tt.want {
+			t.Errorf("spreadsheetSafe(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	report := &linkpatrol.Report{Results: []linkpatrol.Result{
+		{URL: "https://site.example/", Status: linkpatrol.Live},
+		{
+			URL:       "https://site.example/old",
+			Status:    linkpatrol.Dead,
+			Error:     `GET "https://site.example/gone": HTTP 404, "gone"`,
+			Referrer:  "https://site.example/",
+			Redirects: []linkpatrol.Redirect{{URL: "https://site.example/old", StatusCode: 301}, {URL: "https://site.example/gone", StatusCode: 404}},
+		},
+		{URL: "https://site.example/=cmd", Status: linkpatrol.Live, Referrer: "-page"},
+	}}
+	var b strings.Builder
+	if err := WriteCSV(&b, report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"url,status,failure,error,referrer,domain,redirects",
+		`https://site.example/old,Dead,true,"GET ""https://site.example/gone"": HTTP 404, ""gone""",https://site.example/,site.example,301 https://site.example/old -> 404 https://site.example/gone`,
+		"https://site.example/,Live,false,,,site.example,",
+		"https://site.example/=cmd,Live,false,,'-page,site.example,",
+	}, "\n") + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
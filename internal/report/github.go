@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sirprodigle/linkpatrol/internal/sourcemap"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// WriteGitHubAnnotations writes a GitHub Actions workflow command for each
+// failing link, so it shows up on the line of the pull request that added
+// it: an error for broken links and a warning for links that timed out or
+// were blocked. A run that passed, because a baseline knew its failures, only
+// gets warnings. Links are placed with sources, when it knows where they were
+// written.
+func WriteGitHubAnnotations(w io.Writer, report *linkpatrol.Report, sources *sourcemap.Map, passed bool) error {
+	var b strings.Builder
+	if report.Partial {
+		b.WriteString("::warning title=LinkPatrol::The run was interrupted before the crawl finished, so some links were never checked\n")
+	}
+
+	var annotated []linkpatrol.Result
+	for _, result := range report.Results {
+		if result.Status.IsFailure() || result.Status == linkpatrol.Bot {
+			annotated = append(annotated, result)
+		}
+	}
+	sort.Slice(annotated, func(i, j int) bool {
+		if rank(annotated[i].Status) != rank(annotated[j].Status) {
+			return rank(annotated[i].Status) < rank(annotated[j].Status)
+		}
+		return annotated[i].URL < annotated[j].URL
+	})
+
+	for _, result := range annotated {
+		command := "error"
+		if passed || result.Status == linkpatrol.Timeout || result.Status == linkpatrol.Bot {
+			command = "warning"
+		}
+		properties := []string{"title=" + escapeProperty(result.Status.String()+" link")}
+		if location, ok := sources.Locate(result); ok {
+			properties = append(properties, "file="+escapeProperty(location.File))
+			if location.Line > 0 {
+				properties = append(properties, fmt.Sprintf("line=%d", location.Line), fmt.Sprintf("col=%d", location.Column))
+			}
+		}
+
+		// The URL leads the message, so it's left out of the error
+		message := result.URL
+		if reason := requestPrefix.ReplaceAllString(result.Error, ""); reason != "" {
+			message += ": " + reason
+		}
+		if result.Referrer != "" {
+			message += " (found on " + result.Referrer + ")"
+		}
+		fmt.Fprintf(&b, "::%s %s::%s\n", command, strings.Join(properties, ","), escapeData(message))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
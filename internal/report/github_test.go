@@ -0,0 +1,66 @@
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestEscapeData(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain message", "plain message"},
+		{"100% done", "100%25 done"},
+		{"two\nlines\r\n", "two%0Alines%0D%0A"},
+		{"%0A stays literal", "%250A stays literal"},
+		{"a: b, c", "a: b, c"}, // only properties escape : and ,
+	}
+	for _, tt := range tests {
+		if got := escapeData(tt.in); got != tt.want {
+			t.Errorf("escapeData(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeProperty(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"docs/index.md", "docs/index.md"},
+		{"Dead link", "Dead link"},
+		{"C:\\site\\a,b.md", "C%3A\\site\\a%2Cb.md"},
+		{"50%\nnew", "50%25%0Anew"},
+		{"title::with,commas", "title%3A%3Awith%2Ccommas"},
+	}
+	for _, tt := range tests {
+		if got := escapeProperty(tt.in); got != tt.want {
+			t.Errorf("escapeProperty(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteGitHubAnnotations(t *testing.T) {
+	report := &linkpatrol.Report{Results: []linkpatrol.Result{
+		{URL: "https://site.example/ok", Status: linkpatrol.Live},
+		{URL: "https://site.example/slow", Status: linkpatrol.Timeout, Referrer: "https://site.example/"},
+		{URL: "https://site.example/gone", Status: linkpatrol.Dead, Error: "HTTP 404\nNot Found", Referrer: "https://site.example/"},
+	}}
+
+	tests := []struct {
+		passed bool
+		want   string
+	}{
+		{false, "::error title=Dead link::https://site.example/gone: HTTP 404%0ANot Found (found on https://site.example/)\n" +
+			"::warning title=Timeout link::https://site.example/slow (found on https://site.example/)\n"},
+		// A baseline that knows every failure turns them into warnings
+		{true, "::warning title=Dead link::https://site.example/gone: HTTP 404%0ANot Found (found on https://site.example/)\n" +
+			"::warning title=Timeout link::https://site.example/slow (found on https://site.example/)\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := WriteGitHubAnnotations(&b, report, nil, tt.passed); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("passed = %v:\n%s\nwant:\n%s", tt.passed, b.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Package report writes the results of a run to files that can be shared or
+// fed to other tools, rather than read in the terminal
+package report
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+//go:embed html.tmpl
+var htmlTemplate string
+
+var htmlReport = template.Must(template.New("report").Parse(htmlTemplate))
+
+// statusOrder ranks statuses from most to least in need of attention, so
+// failures sort first
+var statusOrder = []linkpatrol.Status{linkpatrol.Dead, linkpatrol.Soft404, linkpatrol.MissingAnchor, linkpatrol.Timeout, linkpatrol.Bot, linkpatrol.Live, linkpatrol.Ignore}
+
+// htmlResult is a result as the report's script sees it
+type htmlResult struct {
+	URL       string                `json:"url"`
+	Status    string                `json:"status"`
+	Rank      int                   `json:"rank"`
+	Failure   bool                  `json:"failure"`
+	Domain    string                `json:"domain"`
+	Referrer  string                `json:"referrer"`
+	Error     string                `json:"error"`
+	Redirects []linkpatrol.Redirect `json:"redirects"`
+}
+
+type statusCount struct {
+	Status  string
+	Count   int
+	Failure bool
+}
+
+// errorGroup is the links that failed with the same error, give or take the URL in it
+type errorGroup struct {
+	Message string
+	Status  string
+	URLs    []string
+}
+
+// pageSummary counts the links first found on a page. Results only keep the
+// first referrer of a link, so a link on several pages counts for one of them.
+type pageSummary struct {
+	URL      string
+	Links    int
+	Failures int
+}
+
+type htmlData struct {
+	Target    string
+	Generated string
+	Partial   bool
+	Total     int
+	Failures  int
+	Counts    []statusCount
+	Groups    []errorGroup
+	Pages     []pageSummary
+	Results   []htmlResult
+}
+
+// WriteHTML writes report as a single HTML page, with its styles and script
+// inlined so it can be passed around as one file
+func WriteHTML(w io.Writer, target string, report *linkpatrol.Report, generated time.Time) error {
+	data := htmlData{
+		Target:    target,
+		Generated: generated.Format(time.RFC1123),
+		Partial:   report.Partial,
+		Total:     len(report.Results),
+		Results:   make([]htmlResult, 0, len(report.Results)),
+	}
+
+	counts := make(map[linkpatrol.Status]int)
+	groups := make(map[string]*errorGroup)
+	pages := make(map[string]*pageSummary)
+	for _, result := range report.Results {
+		counts[result.Status]++
+		failure := result.Status.IsFailure()
+		if failure {
+			data.Failures++
+		}
+
+		data.Results = append(data.Results, htmlResult{
+			URL:       result.URL,
+			Status:    result.Status.String(),
+			Rank:      rank(result.Status),
+			Failure:   failure,
+			Domain:    domainOf(result.URL),
+			Referrer:  result.Referrer,
+			Error:     result.Error,
+			Redirects: result.Redirects,
+		})
+
+		if result.Referrer != "" {
+			page := pages[result.Referrer]
+			if page == nil {
+				page = &pageSummary{URL: result.Referrer}
+				pages[result.Referrer] = page
+			}
+			page.Links++
+			if failure {
+				page.Failures++
+			}
+		}
+
+		// Working and deliberately skipped links can carry notes, but they aren't errors
+		if result.Status == linkpatrol.Live || result.Status == linkpatrol.Ignore || result.Error == "" {
+			continue
+		}
+		message := groupError(result.Error)
+		key := result.Status.String() + "\x00" + message
+		group := groups[key]
+		if group == nil {
+			group = &errorGroup{Message: message, Status: result.Status.String()}
+			groups[key] = group
+		}
+		group.URLs = append(group.URLs, result.URL)
+	}
+
+	for _, status := range statusOrder {
+		if counts[status] > 0 {
+			data.Counts = append(data.Counts, statusCount{Status: status.String(), Count: counts[status], Failure: status.IsFailure()})
+		}
+	}
+	for _, group := range groups {
+		sort.Strings(group.URLs)
+		data.Groups = append(data.Groups, *group)
+	}
+	sort.Slice(data.Groups, func(i, j int) bool {
+		if len(data.Groups[i].URLs) != len(data.Groups[j].URLs) {
+			return len(data.Groups[i].URLs) > len(data.Groups[j].URLs)
+		}
+		return data.Groups[i].Message < data.Groups[j].Message
+	})
+	for _, page := range pages {
+		data.Pages = append(data.Pages, *page)
+	}
+	sort.Slice(data.Pages, func(i, j int) bool {
+		if data.Pages[i].Failures != data.Pages[j].Failures {
+			return data.Pages[i].Failures > data.Pages[j].Failures
+		}
+		return data.Pages[i].URL < data.Pages[j].URL
+	})
+	sort.SliceStable(data.Results, func(i, j int) bool {
+		if data.Results[i].Rank != data.Results[j].Rank {
+			return data.Results[i].Rank < data.Results[j].Rank
+		}
+		return data.Results[i].URL < data.Results[j].URL
+	})
+
+	return htmlReport.Execute(w, data)
+}
+
+func rank(status linkpatrol.Status) int {
+	for i, s := range statusOrder {
+		if s == status {
+			return i
+		}
+	}
+	return len(statusOrder)
+}
+
+// domainOf returns the host of a result's URL, or "" for a URL without one
+func domainOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+var (
+	// requestPrefix is how url.Error starts its message: `GET "https://...": `
+	requestPrefix = regexp.MustCompile(`^[A-Z]+ "[^"]*": `)
+	urlInError    = regexp.MustCompile(`https?://[^\s'"]+`)
+	quotedInError = regexp.MustCompile(`'[^']*'`)
+)
+
+// groupError strips the parts of an error that name the link, so the same
+// problem on different links reads the same
+func groupError(message string) string {
+	message = requestPrefix.ReplaceAllString(message, "")
+	message = urlInError.ReplaceAllString(message, "…")
+	return quotedInError.ReplaceAllString(message, "'…'")
+}
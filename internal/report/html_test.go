@@ -0,0 +1,40 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestWriteHTMLCountsLinksUnderTheirFirstPage(t *testing.T) {
+	report := &linkpatrol.Report{Results: []linkpatrol.Result{
+		{URL: "https://site.example/", Status: linkpatrol.Live},
+		{URL: "https://site.example/a", Status: linkpatrol.Live, Referrer: "https://site.example/"},
+		{URL: "https://site.example/gone", Status: linkpatrol.Dead, Referrer: "https://site.example/"},
+		{URL: "https://site.example/b", Status: linkpatrol.Live, Referrer: "https://site.example/a"},
+	}}
+
+	var b strings.Builder
+	if err := WriteHTML(&b, "https://site.example/", report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// The page with a failure comes first, with its 2 links and 1 failing
+	out := b.String()
+	first := strings.Index(out, `<td><a href="https://site.example/">https://site.example/</a></td>
+      <td>2</td>
+      <td class="s-Dead">1</td>`)
+	second := strings.Index(out, `<td><a href="https://site.example/a">https://site.example/a</a></td>
+      <td>1</td>
+      <td>0</td>`)
+	if first < 0 || second < 0 || first > second {
+		t.Errorf("page rows at %d and %d in:\n%s", first, second, out)
+	}
+	// Only the first page a link was found on is known, and the labels say so
+	for _, want := range []string{"2 pages, by the links first found on them", "Links first found here", "First found on"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report doesn't say %q", want)
+		}
+	}
+}
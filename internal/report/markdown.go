@@ -0,0 +1,230 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sirprodigle/linkpatrol/internal/sourcemap"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// maxErrorLength is the most characters of an error shown in a Markdown table
+const maxErrorLength = 200
+
+var statusEmoji = map[linkpatrol.Status]string{
+	linkpatrol.Live:          "✅",
+	linkpatrol.Timeout:       "⏰",
+	linkpatrol.Bot:           "🤖",
+	linkpatrol.Dead:          "❌",
+	linkpatrol.MissingAnchor: "⚓",
+	linkpatrol.Soft404:       "👻",
+	linkpatrol.Ignore:        "🔕",
+}
+
+// MarkdownOptions controls WriteMarkdown
+type MarkdownOptions struct {
+	// MaxSize is the most bytes written, so the summary fits a PR comment; failures and
+	// domains that don't fit are left out and counted instead. 0 means no limit.
+	MaxSize int
+	// Sources finds where failing links were written, if set
+	Sources *sourcemap.Map
+	// SourceURL is where source files are browsed, for linking to them
+	SourceURL string
+	// Passed is set when the run passed despite its failures, because a
+	// baseline knew about them all
+	Passed bool
+}
+
+type domainSummary struct {
+	domain   string
+	links    int
+	failures int
+}
+
+// WriteMarkdown writes a summary of report for a pull request comment or a
+// GitHub job summary: counts by status up front, with the failures and a
+// breakdown by domain in collapsible sections
+func WriteMarkdown(w io.Writer, target string, report *linkpatrol.Report, opts MarkdownOptions) error {
+	var head strings.Builder
+	broken, timedOut := report.FailureCount()
+	partial := ""
+	if report.Partial {
+		partial = " (partial)"
+	}
+	switch {
+	case !report.HasFailures():
+		fmt.Fprintf(&head, "## ✅ LinkPatrol%s: no broken links\n\n", partial)
+	case opts.Passed:
+		fmt.Fprintf(&head, "## ✅ LinkPatrol%s: no new broken links, %d broken and %d timed out are known\n\n", partial, broken, timedOut)
+	default:
+		fmt.Fprintf(&head, "## ❌ LinkPatrol%s: %d broken and %d timed out links\n\n", partial, broken, timedOut)
+	}
+	if report.Partial {
+		head.WriteString("> [!WARNING]\n> The run was interrupted before the crawl finished, so some links were never checked.\n\n")
+	}
+	if target != "" {
+		fmt.Fprintf(&head, "Checked %d links on %s.\n\n", len(report.Results), target)
+	}
+
+	counts := make(map[linkpatrol.Status]int)
+	domains := make(map[string]*domainSummary)
+	for _, result := range report.Results {
+		counts[result.Status]++
+		name := domainOf(result.URL)
+		domain := domains[name]
+		if domain == nil {
+			domain = &domainSummary{domain: name}
+			domains[name] = domain
+		}
+		domain.links++
+		if result.Status.IsFailure() {
+			domain.failures++
+		}
+	}
+	head.WriteString("| Status | Links |\n|---|---:|\n")
+	for _, status := range statusOrder {
+		if counts[status] > 0 {
+			fmt.Fprintf(&head, "| %s %s | %d |\n", statusEmoji[status], status, counts[status])
+		}
+	}
+	head.WriteString("\n")
+
+	failures := report.Failures()
+	sort.Slice(failures, func(i, j int) bool {
+		if rank(failures[i].Status) != rank(failures[j].Status) {
+			return rank(failures[i].Status) < rank(failures[j].Status)
+		}
+		return failures[i].URL < failures[j].URL
+	})
+	failureRows := make([]string, 0, len(failures))
+	for _, result := range failures {
+		failureRows = append(failureRows, fmt.Sprintf("| %s %s | %s | %s | %s |\n",
+			statusEmoji[result.Status], result.Status, cell(result.URL), foundOn(result, opts), cell(truncate(result.Error, maxErrorLength))))
+	}
+
+	sorted := make([]*domainSummary, 0, len(domains))
+	for _, domain := range domains {
+		sorted = append(sorted, domain)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].failures != sorted[j].failures {
+			return sorted[i].failures > sorted[j].failures
+		}
+		if sorted[i].links != sorted[j].links {
+			return sorted[i].links > sorted[j].links
+		}
+		return sorted[i].domain < sorted[j].domain
+	})
+	domainRows := make([]string, 0, len(sorted))
+	for _, domain := range sorted {
+		domainRows = append(domainRows, fmt.Sprintf("| %s | %d | %d |\n", cell(domain.domain), domain.links, domain.failures))
+	}
+
+	var out strings.Builder
+	out.WriteString(head.String())
+	budget := &sizeBudget{max: opts.MaxSize, used: out.Len()}
+	if len(failureRows) > 0 {
+		// Keep room for the note saying the domains were left out, if they don't fit
+		after := 0
+		if len(domainRows) > 0 {
+			after = noteRoom
+		}
+		section(&out, budget, fmt.Sprintf("Failures (%d)", len(failureRows)), true,
+			"| Status | Link | Found on | Error |\n|---|---|---|---|\n", failureRows, "failures", after)
+	}
+	if len(domainRows) > 0 {
+		section(&out, budget, fmt.Sprintf("By domain (%d)", len(domainRows)), false,
+			"| Domain | Links | Failing |\n|---|---:|---:|\n", domainRows, "domains", 0)
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// sizeBudget tracks how many bytes of the summary are left
+type sizeBudget struct {
+	max  int // 0 = no limit
+	used int
+}
+
+// take uses n bytes, leaving reserve for what still has to follow, and
+// reports whether they fit
+func (b *sizeBudget) take(n, reserve int) bool {
+	if b.max > 0 && b.used+n+reserve > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// noteRoom is the room kept for the note about rows left out
+const noteRoom = 100
+
+// section writes a collapsible table with as many rows as fit the budget,
+// keeping after bytes for what follows, and notes how many rows were left out.
+// The note is written even when not a single row fits.
+func section(out *strings.Builder, budget *sizeBudget, summary string, open bool, header string, rows []string, noun string, after int) {
+	start := "<details>"
+	if open {
+		start = "<details open>"
+	}
+	start += "\n<summary>" + summary + "</summary>\n\n"
+	const end = "\n</details>\n\n"
+	reserve := len(end) + noteRoom + after
+	if !budget.take(len(start)+len(header), reserve) {
+		note := fmt.Sprintf("_%s: all %d %s left out to keep this summary short._\n\n", summary, len(rows), noun)
+		budget.take(len(note), 0)
+		out.WriteString(note)
+		return
+	}
+	out.WriteString(start)
+	out.WriteString(header)
+
+	shown := 0
+	for _, row := range rows {
+		if !budget.take(len(row), reserve) {
+			break
+		}
+		out.WriteString(row)
+		shown++
+	}
+	if shown < len(rows) {
+		note := fmt.Sprintf("\n_…and %d more %s, left out to keep this summary short._\n", len(rows)-shown, noun)
+		budget.take(len(note), 0)
+		out.WriteString(note)
+	}
+	budget.take(len(end), 0)
+	out.WriteString(end)
+}
+
+// foundOn describes the page a failure was found on, with the source file and
+// line it was written on when they're known
+func foundOn(result linkpatrol.Result, opts MarkdownOptions) string {
+	if result.Referrer == "" {
+		return "_start page_"
+	}
+	page := cell(result.Referrer)
+	location, ok := opts.Sources.Locate(result)
+	if !ok {
+		return page
+	}
+	source := "`" + strings.ReplaceAll(location.String(), "`", "'") + "`"
+	if link := location.URL(opts.SourceURL); link != "" {
+		source = "[" + source + "](" + link + ")"
+	}
+	return page + "<br>" + source
+}
+
+// cell escapes text for a Markdown table cell
+func cell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\r", " ", "\n", " ").Replace(text)
+}
+
+func truncate(text string, max int) string {
+	if runes := []rune(text); len(runes) > max {
+		return string(runes[:max-1]) + "…"
+	}
+	return text
+}
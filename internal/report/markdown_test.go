@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func markdown(t *testing.T, report *linkpatrol.Report, opts MarkdownOptions) string {
+	t.Helper()
+	var b strings.Builder
+	if err := WriteMarkdown(&b, "https://site.example/", report, opts); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWriteMarkdownHeaderFollowsTheVerdict(t *testing.T) {
+	failing := &linkpatrol.Report{Results: []linkpatrol.Result{
+		{URL: "https://site.example/gone", Status: linkpatrol.Dead},
+	}}
+	tests := []struct {
+		name   string
+		report *linkpatrol.Report
+		passed bool
+		want   string
+	}{
+		{"no failures", &linkpatrol.Report{Results: []linkpatrol.Result{{URL: "https://site.example/", Status: linkpatrol.Live}}}, true, "## ✅ LinkPatrol: no broken links"},
+		{"failures", failing, false, "## ❌ LinkPatrol: 1 broken and 0 timed out links"},
+		{"failures the baseline knows", failing, true, "## ✅ LinkPatrol: no new broken links, 1 broken and 0 timed out are known"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out := markdown(t, tt.report, MarkdownOptions{Passed: tt.passed}); !strings.HasPrefix(out, tt.want+"\n") {
+				t.Errorf("header of:\n%s\nwant %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdownNotesWhatDoesNotFit(t *testing.T) {
+	report := &linkpatrol.Report{}
+	for i := range 200 {
+		report.Results = append(report.Results, linkpatrol.Result{
+			URL:    fmt.Sprintf("https://host%03d.example/page", i),
+			Status: linkpatrol.Dead,
+			Error:  "HTTP 404",
+		})
+	}
+	full := markdown(t, report, MarkdownOptions{})
+
+	for _, maxSize := range []int{1000, 2000, 5000, 20000} {
+		out := markdown(t, report, MarkdownOptions{MaxSize: maxSize})
+		if len(out) > maxSize {
+			t.Errorf("MaxSize %d: wrote %d bytes", maxSize, len(out))
+		}
+		// Every section is either shown in full, cut short with a note, or left out with a note
+		lastRows := map[string]string{
+			"failures": "| https://host199.example/page |",
+			"domains":  "| host199.example | 1 | 1 |",
+		}
+		for noun, lastRow := range lastRows {
+			noted := strings.Contains(out, " more "+noun+", left out") || strings.Contains(out, " "+noun+" left out")
+			if strings.Contains(out, lastRow) == noted {
+				t.Errorf("MaxSize %d: %s shown in full = %v, noted = %v:\n%s", maxSize, noun, !noted, noted, out)
+			}
+		}
+	}
+	if strings.Contains(full, "left out") {
+		t.Error("a summary without a size limit left rows out")
+	}
+}
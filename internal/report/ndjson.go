@@ -0,0 +1,64 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// ndjsonRecord is the JSON form of a result in the stream
+type ndjsonRecord struct {
+	Time      time.Time        `json:"time"`
+	URL       string           `json:"url"`
+	Status    string           `json:"status"`
+	Failure   bool             `json:"failure"`
+	Error     string           `json:"error,omitempty"`
+	Referrer  string           `json:"referrer,omitempty"`
+	Redirects []ndjsonRedirect `json:"redirects,omitempty"`
+}
+
+type ndjsonRedirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+}
+
+// NDJSON streams results as line-delimited JSON as they're recorded, so a
+// long crawl can be tailed or piped into jq while it runs. Write isn't safe
+// for concurrent use; results are recorded one at a time.
+type NDJSON struct {
+	encoder *json.Encoder
+	err     error
+}
+
+func NewNDJSON(w io.Writer) *NDJSON {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &NDJSON{encoder: encoder}
+}
+
+// Write writes result as one line. After a failed write the rest are
+// dropped and Err returns why.
+func (n *NDJSON) Write(result linkpatrol.Result) {
+	if n.err != nil {
+		return
+	}
+	record := ndjsonRecord{
+		Time:     time.Now(),
+		URL:      result.URL,
+		Status:   result.Status.String(),
+		Failure:  result.Status.IsFailure(),
+		Error:    result.Error,
+		Referrer: result.Referrer,
+	}
+	for _, hop := range result.Redirects {
+		record.Redirects = append(record.Redirects, ndjsonRedirect{URL: hop.URL, StatusCode: hop.StatusCode})
+	}
+	n.err = n.encoder.Encode(record)
+}
+
+// Err returns the error that stopped the stream, if any
+func (n *NDJSON) Err() error {
+	return n.err
+}
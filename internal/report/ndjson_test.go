@@ -0,0 +1,81 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestNDJSONWritesOneObjectPerLine(t *testing.T) {
+	var b strings.Builder
+	stream := NewNDJSON(&b)
+	stream.Write(linkpatrol.Result{URL: "https://site.example/", Status: linkpatrol.Live})
+	stream.Write(linkpatrol.Result{
+		URL:       "https://site.example/old?a=1&b=<2>",
+		Status:    linkpatrol.Dead,
+		Error:     "HTTP 404",
+		Referrer:  "https://site.example/",
+		Redirects: []linkpatrol.Redirect{{URL: "https://site.example/old", StatusCode: 301}, {URL: "https://site.example/gone", StatusCode: 404}},
+	})
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2:\n%s", len(lines), b.String())
+	}
+	if !strings.Contains(lines[1], `"url":"https://site.example/old?a=1&b=<2>"`) {
+		t.Errorf("URL was HTML-escaped: %s", lines[1])
+	}
+
+	var live, dead map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &live); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &dead); err != nil {
+		t.Fatal(err)
+	}
+	if live["url"] != "https://site.example/" || live["status"] != "Live" || live["failure"] != false {
+		t.Errorf("first line = %s", lines[0])
+	}
+	for _, omitted := range []string{"error", "referrer", "redirects"} {
+		if _, found := live[omitted]; found {
+			t.Errorf("first line has an empty %q: %s", omitted, lines[0])
+		}
+	}
+	if _, found := live["time"]; !found {
+		t.Errorf("first line has no time: %s", lines[0])
+	}
+	if dead["url"] != "https://site.example/old?a=1&b=<2>" || dead["status"] != "Dead" || dead["failure"] != true || dead["error"] != "HTTP 404" || dead["referrer"] != "https://site.example/" {
+		t.Errorf("second line = %s", lines[1])
+	}
+	redirects, _ := json.Marshal(dead["redirects"])
+	if want := `[{"status_code":301,"url":"https://site.example/old"},{"status_code":404,"url":"https://site.example/gone"}]`; string(redirects) != want {
+		t.Errorf("redirects = %s, want %s", redirects, want)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{ writes int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestNDJSONStopsAfterAFailedWrite(t *testing.T) {
+	w := &failingWriter{}
+	stream := NewNDJSON(w)
+	stream.Write(linkpatrol.Result{URL: "https://site.example/a", Status: linkpatrol.Live})
+	stream.Write(linkpatrol.Result{URL: "https://site.example/b", Status: linkpatrol.Live})
+	if err := stream.Err(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Err() = %v, want the write error", err)
+	}
+	if w.writes != 1 {
+		t.Errorf("tried %d writes, want 1", w.writes)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// budget shares a fixed number of workers between jobs, so concurrent scans
+// can't overload the server or the network between them
+type budget struct {
+	total int
+
+	mu      sync.Mutex
+	used    int
+	changed chan struct{} // closed and replaced whenever workers are released
+}
+
+func newBudget(total int) *budget {
+	return &budget{
+		total:   total,
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until n workers are free and takes them, or returns ctx's
+// error if it's cancelled first
+func (b *budget) acquire(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.total {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release returns n workers taken by acquire and wakes the jobs waiting for them
+func (b *budget) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// inUse returns how many workers running jobs hold
+func (b *budget) inUse() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+type jobState string
+
+const (
+	stateQueued    jobState = "queued" // waiting for the worker budget
+	stateRunning   jobState = "running"
+	stateDone      jobState = "done"
+	stateCancelled jobState = "cancelled"
+	stateFailed    jobState = "failed"
+)
+
+// jobRequest is the body of a scan submission: the target plus overrides of
+// the server's config, named like the command line flags
+type jobRequest struct {
+	Target string `json:"target"`
+	Config struct {
+		Walkers              *int     `json:"walkers"`
+		Testers              *int     `json:"testers"`
+		Timeout              *string  `json:"timeout"`
+		Rate                 *int     `json:"rate"`
+		MaxPerHost           *int     `json:"max-per-host"`
+		CheckExternalAnchors *bool    `json:"check-external-anchors"`
+		Soft404              *bool    `json:"soft404"`
+		Soft404Phrases       []string `json:"soft404-phrases"`
+		HeaderProfile        *string  `json:"header-profile"`
+	} `json:"config"`
+}
+
+// apply returns a copy of base with the request's target and overrides
+func (r *jobRequest) apply(base *config.Config) (*config.Config, error) {
+	cfg := *base
+	cfg.Target = strings.TrimSpace(r.Target)
+	if cfg.Target == "" {
+		return nil, errors.New("target is required")
+	}
+
+	overrides := r.Config
+	for name, value := range map[string]*int{"walkers": overrides.Walkers, "testers": overrides.Testers, "max-per-host": overrides.MaxPerHost} {
+		if value != nil && *value <= 0 {
+			return nil, fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if overrides.Walkers != nil {
+		cfg.Walkers = *overrides.Walkers
+	}
+	if overrides.Testers != nil {
+		cfg.Testers = *overrides.Testers
+		cfg.MaxTesters = 4 * cfg.Testers
+	}
+	if overrides.Timeout != nil {
+		timeout, err := time.ParseDuration(*overrides.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", *overrides.Timeout)
+		}
+		cfg.Timeout = timeout
+	}
+	if overrides.Rate != nil {
+		if *overrides.Rate < 0 {
+			return nil, errors.New("rate can't be negative")
+		}
+		cfg.Rate = *overrides.Rate
+	}
+	if overrides.MaxPerHost != nil {
+		cfg.MaxPerHost = *overrides.MaxPerHost
+	}
+	if overrides.CheckExternalAnchors != nil {
+		cfg.CheckExternalAnchors = *overrides.CheckExternalAnchors
+	}
+	if overrides.Soft404 != nil {
+		cfg.Soft404 = *overrides.Soft404
+	}
+	if overrides.Soft404Phrases != nil {
+		cfg.Soft404Phrases = overrides.Soft404Phrases
+	}
+	if overrides.HeaderProfile != nil {
+		cfg.HeaderProfile = *overrides.HeaderProfile
+	}
+	return &cfg, nil
+}
+
+// job is one scan, run by a checker of its own so it shares nothing with other jobs
+type job struct {
+	id      string
+	config  *config.Config
+	checker *linkpatrol.Checker
+	client  *http.Client
+	workers int // walkers and testers taken from the server's budget while running
+	created time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	state    jobState
+	err      string
+	started  time.Time
+	finished time.Time
+	results  map[string]linkpatrol.Result
+}
+
+// record stores a result as soon as the checker reports it, so results can
+// be fetched while the job runs
+func (j *job) record(result linkpatrol.Result) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.results[result.URL] = result
+}
+
+func (j *job) setState(state jobState, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+	switch state {
+	case stateRunning:
+		j.started = time.Now()
+	case stateDone, stateCancelled, stateFailed:
+		j.finished = time.Now()
+	}
+	if err != nil {
+		j.err = err.Error()
+	}
+}
+
+func (j *job) isQueued() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state == stateQueued
+}
+
+func (j *job) isFinished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state == stateDone || j.state == stateCancelled || j.state == stateFailed
+}
+
+// jobStats is the JSON form of the live stats of a running job
+type jobStats struct {
+	ActiveWalkers   int `json:"active_walkers"`
+	ActiveTesters   int `json:"active_testers"`
+	DomainCount     int `json:"domain_count"`
+	ResultsObtained int `json:"results_obtained"`
+	ResultsToTest   int `json:"results_to_test"`
+	PathsToWalk     int `json:"paths_to_walk"`
+}
+
+type jobStatus struct {
+	ID       string         `json:"id"`
+	Target   string         `json:"target"`
+	State    jobState       `json:"state"`
+	Error    string         `json:"error,omitempty"`
+	Workers  int            `json:"workers"`
+	Created  time.Time      `json:"created"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Stats    *jobStats      `json:"stats,omitempty"`
+	Results  map[string]int `json:"results"` // number of results by status
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := jobStatus{
+		ID:      j.id,
+		Target:  j.config.Target,
+		State:   j.state,
+		Error:   j.err,
+		Workers: j.workers,
+		Created: j.created,
+		Results: make(map[string]int),
+	}
+	if started := j.started; !started.IsZero() {
+		status.Started = &started
+	}
+	if finished := j.finished; !finished.IsZero() {
+		status.Finished = &finished
+	}
+	if j.state == stateRunning {
+		if stats, ok := j.checker.Stats(); ok {
+			status.Stats = &jobStats{
+				ActiveWalkers:   stats.ActiveWalkers,
+				ActiveTesters:   stats.ActiveTesters,
+				DomainCount:     stats.DomainCount,
+				ResultsObtained: stats.ResultsObtained,
+				ResultsToTest:   stats.ResultsToTest,
+				PathsToWalk:     stats.PathsToWalk,
+			}
+		}
+	}
+	for _, result := range j.results {
+		status.Results[result.Status.String()]++
+	}
+	return status
+}
+
+// jobResult is the JSON form of a linkpatrol.Result
+type jobResult struct {
+	URL      string `json:"url"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+type jobResults struct {
+	ID    string   `json:"id"`
+	State jobState `json:"state"`
+	// Partial is set until the job is done, including when it was cancelled, so
+	// some links may not have been checked
+	Partial bool        `json:"partial"`
+	Results []jobResult `json:"results"`
+}
+
+func (j *job) resultList() jobResults {
+	j.mu.Lock()
+	out := jobResults{
+		ID:      j.id,
+		State:   j.state,
+		Partial: j.state != stateDone,
+		Results: make([]jobResult, 0, len(j.results)),
+	}
+	for _, result := range j.results {
+		out.Results = append(out.Results, jobResult{
+			URL:      result.URL,
+			Status:   result.Status.String(),
+			Error:    result.Error,
+			Referrer: result.Referrer,
+		})
+	}
+	j.mu.Unlock()
+
+	sort.Slice(out.Results, func(a, b int) bool {
+		return out.Results[a].URL < out.Results[b].URL
+	})
+	return out
+}
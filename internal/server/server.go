@@ -0,0 +1,387 @@
+// Package server runs link checks submitted over a REST API, for running
+// LinkPatrol as a long-lived service
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/app"
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/metrics"
+	"github.com/sirprodigle/linkpatrol/internal/profiles"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+const (
+	// maxRequestBytes caps the size of a job submission
+	maxRequestBytes = 1 << 20
+	// maxFinishedJobs is how many finished jobs are kept for their results before the oldest are dropped
+	maxFinishedJobs = 100
+)
+
+// errTooManyQueued rejects a submission while max-queued jobs wait for workers
+var errTooManyQueued = errors.New("too many jobs are waiting for workers, try again later")
+
+// Server accepts scan jobs and runs each with its own checker, within a
+// worker budget shared by every job
+type Server struct {
+	config  *config.Config
+	logger  *logger.Logger
+	budget  *budget
+	metrics *metrics.Metrics
+
+	// ctx is the parent of every job's context, cancelled when the server stops
+	ctx context.Context
+	// running tracks the jobs' goroutines, so Run can wait for them to finish
+	running sync.WaitGroup
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // job IDs, oldest first
+}
+
+// New returns a server whose jobs start from cfg and share cfg.MaxWorkers workers
+func New(cfg *config.Config) (*Server, error) {
+	if cfg.MaxWorkers <= 0 {
+		return nil, fmt.Errorf("max-workers must be positive")
+	}
+	if cfg.MaxQueued <= 0 {
+		return nil, fmt.Errorf("max-queued must be positive")
+	}
+	return &Server{
+		config:  cfg,
+		logger:  logger.New(cfg.Verbose),
+		budget:  newBudget(cfg.MaxWorkers),
+		metrics: metrics.New(cfg.MetricsPerHost),
+		ctx:     context.Background(),
+		jobs:    make(map[string]*job),
+	}, nil
+}
+
+// Handler returns the REST API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/results", s.handleResults)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancel)
+	mux.Handle("GET /metrics", s.metrics.Handler())
+	return s.authorize(mux)
+}
+
+// authorize answers 401 to requests without the configured bearer token.
+// Without a token every request is let through.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.config.Token == "" {
+		return next
+	}
+	want := []byte("Bearer " + s.config.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="linkpatrol"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run serves the API on cfg.Listen until ctx is cancelled, then cancels the
+// running jobs and waits up to cfg.GracePeriod for them and for in-flight API
+// requests to finish
+func (s *Server) Run(ctx context.Context) error {
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	s.ctx = jobsCtx
+
+	listener, err := net.Listen("tcp", s.config.Listen)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- httpServer.Serve(listener)
+	}()
+	s.logger.Info("Listening on %s with %d workers shared between jobs", listener.Addr(), s.config.MaxWorkers)
+	if s.config.Token == "" && !isLoopback(listener.Addr()) {
+		s.logger.Warn("Anyone who can reach %s can start scans from this host: set --token or LINKPATROL_TOKEN", listener.Addr())
+	}
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Shutdown()
+	cancelJobs()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.GracePeriod)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+
+	jobsDone := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		s.logger.Warn("Stopped without waiting for every job to finish")
+	}
+	return err
+}
+
+// jobList is the body of GET /jobs
+type jobList struct {
+	MaxWorkers   int         `json:"max_workers"`
+	WorkersInUse int         `json:"workers_in_use"`
+	Jobs         []jobStatus `json:"jobs"`
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %w", err))
+		return
+	}
+
+	j, err := s.submit(&req)
+	if errors.Is(err, errTooManyQueued) {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	list := jobList{
+		MaxWorkers: s.config.MaxWorkers,
+		Jobs:       make([]jobStatus, 0, len(s.order)),
+	}
+	for _, id := range s.order {
+		list.Jobs = append(list.Jobs, s.jobs[id].status())
+	}
+	s.mu.Unlock()
+	list.WorkersInUse = s.budget.inUse()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if j := s.job(w, r); j != nil {
+		writeJSON(w, http.StatusOK, j.status())
+	}
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if j := s.job(w, r); j != nil {
+		writeJSON(w, http.StatusOK, j.resultList())
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	if j.isFinished() {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has already finished", j.id))
+		return
+	}
+	j.cancel()
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+// job looks up the job named in the request path, answering 404 if there's none
+func (s *Server) job(w http.ResponseWriter, r *http.Request) *job {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	j := s.jobs[id]
+	s.mu.Unlock()
+	if j == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %q", id))
+	}
+	return j
+}
+
+// submit validates a job request and starts the job in the background
+func (s *Server) submit(req *jobRequest) (*job, error) {
+	cfg, err := req.apply(s.config)
+	if err != nil {
+		return nil, err
+	}
+	options, expired, err := app.NewOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := options.Walkers + max(options.Testers, options.MaxTesters)
+	if workers > s.config.MaxWorkers {
+		return nil, fmt.Errorf("job needs %d walkers and testers but the server only has %d", workers, s.config.MaxWorkers)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &job{
+		id:      id,
+		config:  cfg,
+		client:  options.Client,
+		workers: workers,
+		created: time.Now(),
+		cancel:  cancel,
+		state:   stateQueued,
+		results: make(map[string]linkpatrol.Result),
+	}
+	options.OnResult = j.record
+	s.metrics.Instrument(&options)
+	if cfg.Verbose {
+		options.Output = os.Stdout
+	}
+	if j.checker, err = linkpatrol.New(options); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.queued() >= s.config.MaxQueued {
+		s.mu.Unlock()
+		cancel()
+		return nil, errTooManyQueued
+	}
+	s.jobs[id] = j
+	s.order = append(s.order, id)
+	s.pruneJobs()
+	s.mu.Unlock()
+
+	s.logger.Info("Queued job %s for %s", id, cfg.Target)
+	for _, rule := range expired {
+		s.logger.Warn("Job %s: suppression for %s expired on %s and no longer applies (%s)", id, rule.Pattern, rule.Expires, rule.Describe())
+	}
+	s.running.Add(1)
+	go s.run(ctx, j)
+	return j, nil
+}
+
+// run waits for the job's share of the worker budget, then runs its checker
+func (s *Server) run(ctx context.Context, j *job) {
+	defer s.running.Done()
+	defer j.cancel()
+
+	if err := s.budget.acquire(ctx, j.workers); err != nil {
+		j.setState(stateCancelled, nil)
+		s.logger.Info("Cancelled job %s before it started", j.id)
+		return
+	}
+	defer s.budget.release(j.workers)
+	defer s.metrics.Track(j.checker)()
+
+	j.setState(stateRunning, nil)
+	s.logger.Info("Started job %s for %s", j.id, j.config.Target)
+
+	if j.config.Login.URL != "" {
+		if err := profiles.Login(ctx, j.client, j.config.Login); err != nil {
+			j.setState(stateFailed, err)
+			s.logger.Error("Job %s failed to log in: %s", j.id, err)
+			return
+		}
+	}
+
+	report, err := j.checker.Run(ctx)
+	switch {
+	case errors.Is(err, linkpatrol.ErrInterrupted):
+		j.setState(stateCancelled, nil)
+		s.logger.Warn("Cancelled job %s after %d results", j.id, len(report.Results))
+	case err != nil:
+		j.setState(stateFailed, err)
+		s.logger.Error("Job %s failed: %s", j.id, err)
+	default:
+		j.setState(stateDone, nil)
+		broken, timedOut := report.FailureCount()
+		s.logger.Success("Finished job %s: %d results, %d broken and %d timed out", j.id, len(report.Results), broken, timedOut)
+	}
+}
+
+// queued counts the jobs waiting for workers. s.mu must be held.
+func (s *Server) queued() int {
+	count := 0
+	for _, j := range s.jobs {
+		if j.isQueued() {
+			count++
+		}
+	}
+	return count
+}
+
+// pruneJobs drops the oldest finished jobs beyond maxFinishedJobs. s.mu must be held.
+func (s *Server) pruneJobs() {
+	finished := 0
+	for _, id := range s.order {
+		if s.jobs[id].isFinished() {
+			finished++
+		}
+	}
+	kept := s.order[:0]
+	for _, id := range s.order {
+		if finished > maxFinishedJobs && s.jobs[id].isFinished() {
+			delete(s.jobs, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// isLoopback reports whether addr only accepts connections from this host
+func isLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
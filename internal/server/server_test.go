@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+func TestHandlerRequiresToken(t *testing.T) {
+	s, err := New(&config.Config{MaxWorkers: 10, MaxQueued: 10, Token: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/jobs", "/metrics"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("GET %s with %q = %d, want %d", path, tt.authorization, rec.Code, tt.want)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("GET %s with %q: 401 without a WWW-Authenticate challenge", path, tt.authorization)
+			}
+		}
+	}
+}
+
+func TestHandlerWithoutToken(t *testing.T) {
+	s, err := New(&config.Config{MaxWorkers: 10, MaxQueued: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /jobs = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestSubmitRefusesJobsBeyondMaxQueued(t *testing.T) {
+	s, err := New(&config.Config{
+		Walkers:       1,
+		Testers:       1,
+		MaxTesters:    1,
+		Timeout:       time.Second,
+		HeaderProfile: "linkpatrol",
+		MaxWorkers:    2,
+		MaxQueued:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.ctx = ctx
+	// Hold every worker so submitted jobs stay queued
+	if err := s.budget.acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	handler := s.Handler()
+
+	submit := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"target": "http://127.0.0.1:1/"}`)))
+		return rec.Code
+	}
+	for i := range 2 {
+		if code := submit(); code != http.StatusAccepted {
+			t.Fatalf("job %d = %d, want %d", i, code, http.StatusAccepted)
+		}
+	}
+	if code := submit(); code != http.StatusTooManyRequests {
+		t.Errorf("job beyond max-queued = %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// A queued job starting frees a place in the queue
+	s.budget.release(2)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		queued := s.queued()
+		s.mu.Unlock()
+		if queued < 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no queued job started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := submit(); code != http.StatusAccepted {
+		t.Errorf("job after one started = %d, want %d", code, http.StatusAccepted)
+	}
+}
@@ -0,0 +1,227 @@
+// Package sourcemap finds where in a site's source a link was written, so
+// reports can point at the file and line to fix rather than the built page
+package sourcemap
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/glob"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// Location is where a link was written
+type Location struct {
+	File   string
+	Line   int // 1-based; 0 when the link couldn't be found in the file
+	Column int // 1-based, in characters
+}
+
+type rule struct {
+	path *regexp.Regexp
+	file string
+}
+
+// Map maps the paths of crawled pages to the files they're built from
+type Map struct {
+	rules []rule
+
+	mu    sync.Mutex
+	files map[string][]byte // contents by file name, nil if it couldn't be read
+}
+
+// New compiles the mappings. In each, * in the path matches any run of
+// characters and fills in the * at the same position in the file.
+func New(mappings []config.SourceMapping) *Map {
+	m := &Map{files: make(map[string][]byte)}
+	for _, mapping := range mappings {
+		m.rules = append(m.rules, rule{
+			path: glob.Compile(mapping.Path),
+			file: mapping.File,
+		})
+	}
+	return m
+}
+
+// File returns the source file of the page at pageURL, using the first
+// mapping that matches its path. A file ending in / gets index.html appended.
+func (m *Map) File(pageURL string) (string, bool) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", false
+	}
+	pagePath := u.Path
+	if pagePath == "" {
+		pagePath = "/"
+	}
+	for _, r := range m.rules {
+		captures := r.path.FindStringSubmatch(pagePath)
+		if captures == nil {
+			continue
+		}
+		file := r.file
+		for _, capture := range captures[1:] {
+			if before, after, found := strings.Cut(file, "*"); found {
+				file = before + capture + after
+			}
+		}
+		if file == "" || strings.HasSuffix(file, "/") {
+			file += "index.html"
+		}
+		return file, true
+	}
+	return "", false
+}
+
+// Locate finds the link of result in the source file of the page it was
+// found on. It reports false when no mapping matches the page or the file
+// can't be read; a Location without a Line means the file was found but the
+// link wasn't.
+func (m *Map) Locate(result linkpatrol.Result) (Location, bool) {
+	if m == nil || result.Referrer == "" {
+		return Location{}, false
+	}
+	file, ok := m.File(result.Referrer)
+	if !ok {
+		return Location{}, false
+	}
+	contents := m.read(file)
+	if contents == nil {
+		return Location{}, false
+	}
+
+	location := Location{File: file}
+	for _, candidate := range candidates(result.URL, result.Referrer) {
+		if offset := find(contents, candidate); offset >= 0 {
+			location.Line, location.Column = position(contents, offset)
+			break
+		}
+	}
+	return location, true
+}
+
+func (m *Map) read(file string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	contents, cached := m.files[file]
+	if !cached {
+		var err error
+		if contents, err = os.ReadFile(file); err != nil {
+			contents = nil
+		}
+		m.files[file] = contents
+	}
+	return contents
+}
+
+// candidates returns the ways the link may have been written on the page,
+// from the most to the least specific
+func candidates(link, referrer string) []string {
+	written := []string{link}
+	u, err := url.Parse(link)
+	if err != nil {
+		return written
+	}
+	base, err := url.Parse(referrer)
+	if err != nil || u.Host != base.Host {
+		return appendEscaped(written)
+	}
+
+	suffix := ""
+	if u.Fragment != "" {
+		suffix = "#" + u.EscapedFragment()
+	}
+	absolute := u.RequestURI() + suffix
+	written = append(written, absolute)
+	if u.Path == base.Path && u.RawQuery == "" && suffix != "" {
+		written = append(written, suffix)
+	}
+	// Relative to the page's directory
+	if dir := path.Dir(base.Path) + "/"; dir != "//" && strings.HasPrefix(absolute, dir) {
+		written = append(written, strings.TrimPrefix(absolute, dir))
+	}
+	return appendEscaped(written)
+}
+
+// appendEscaped adds the HTML-escaped form of links with & in them
+func appendEscaped(written []string) []string {
+	for _, link := range written {
+		if strings.Contains(link, "&") {
+			written = append(written, strings.ReplaceAll(link, "&", "&amp;"))
+		}
+	}
+	return written
+}
+
+// find returns the offset of the first occurrence of link that stands on its
+// own, so /docs isn't found inside /docs/setup.html, or -1
+func find(contents []byte, link string) int {
+	if link == "" {
+		return -1
+	}
+	for from := 0; ; {
+		i := bytes.Index(contents[from:], []byte(link))
+		if i < 0 {
+			return -1
+		}
+		start := from + i
+		end := start + len(link)
+		if (start == 0 || strings.IndexByte("\"'(<= \t\n,", contents[start-1]) >= 0) &&
+			(end == len(contents) || strings.IndexByte("\"')> \t\r\n,", contents[end]) >= 0) {
+			return start
+		}
+		from = start + 1
+	}
+}
+
+// position converts an offset into a 1-based line and character column
+func position(contents []byte, offset int) (line, column int) {
+	before := contents[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// GitHubBaseURL returns the URL files of the commit being built are browsed
+// at, when running in GitHub Actions, or ""
+func GitHubBaseURL() string {
+	server, repository, sha := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_SHA")
+	if server == "" || repository == "" || sha == "" {
+		return ""
+	}
+	return server + "/" + repository + "/blob/" + sha
+}
+
+// URL returns where location can be browsed under baseURL, pointing at its
+// line when it's known, or "" without a baseURL
+func (l Location) URL(baseURL string) string {
+	if baseURL == "" {
+		return ""
+	}
+	link := strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(path.Clean(l.File), "/")
+	if l.Line > 0 {
+		link += "#L" + strconv.Itoa(l.Line)
+	}
+	return link
+}
+
+// String formats location as file:line:column, leaving out what isn't known
+func (l Location) String() string {
+	switch {
+	case l.Line == 0:
+		return l.File
+	case l.Column == 0:
+		return fmt.Sprintf("%s:%d", l.File, l.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+	}
+}
@@ -0,0 +1,163 @@
+package sourcemap
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func TestFile(t *testing.T) {
+	m := New([]config.SourceMapping{
+		{Path: "/blog/*/*.html", File: "content/posts/*/*.md"},
+		{Path: "/docs/*.html", File: "docs/*.md"},
+		{Path: "/docs/*", File: "docs/*"},
+		{Path: "/", File: "site/"},
+	})
+	tests := []struct {
+		page, want string
+		found      bool
+	}{
+		{"https://site.example/blog/2024/launch.html", "content/posts/2024/launch.md", true},
+		{"https://site.example/docs/setup.html", "docs/setup.md", true},
+		{"https://site.example/docs/setup.html?tab=linux#step-2", "docs/setup.md", true},
+		{"https://site.example/docs/guide/", "docs/guide/index.html", true},
+		{"https://site.example/docs/a/b.html", "docs/a/b.md", true},
+		{"https://site.example", "site/index.html", true},
+		{"https://site.example/", "site/index.html", true},
+		{"https://site.example/about", "", false},
+		{"://bad", "", false},
+	}
+	for _, tt := range tests {
+		file, found := m.File(tt.page)
+		if file != tt.want || found != tt.found {
+			t.Errorf("File(%q) = %q, %v, want %q, %v", tt.page, file, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	tests := []struct {
+		link, referrer string
+		want           []string
+	}{
+		{
+			"https://site.example/docs/setup.html", "https://site.example/docs/index.html",
+			[]string{"https://site.example/docs/setup.html", "/docs/setup.html", "setup.html"},
+		},
+		{
+			"https://site.example/docs/index.html#install", "https://site.example/docs/index.html",
+			[]string{"https://site.example/docs/index.html#install", "/docs/index.html#install", "#install", "index.html#install"},
+		},
+		{
+			"https://site.example/search?q=a&page=2", "https://site.example/",
+			[]string{"https://site.example/search?q=a&page=2", "/search?q=a&page=2", "https://site.example/search?q=a&amp;page=2", "/search?q=a&amp;page=2"},
+		},
+		{
+			"https://other.example/a?b=1&c=2", "https://site.example/",
+			[]string{"https://other.example/a?b=1&c=2", "https://other.example/a?b=1&amp;c=2"},
+		},
+		{
+			"https://site.example/about", "https://site.example/",
+			[]string{"https://site.example/about", "/about"},
+		},
+	}
+	for _, tt := range tests {
+		if got := candidates(tt.link, tt.referrer); !slices.Equal(got, tt.want) {
+			t.Errorf("candidates(%q, %q) =\n%q\nwant\n%q", tt.link, tt.referrer, got, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		contents, link string
+		want           int
+	}{
+		{`<a href="/docs">`, "/docs", 9},
+		{`<a href="/docs/setup.html"> <a href="/docs">`, "/docs", 37},
+		{"[docs](/docs)", "/docs", 7},
+		{"see /docs, then", "/docs", 4},
+		{`<a href='/docs'>`, "/docs", 9},
+		{"/docs", "/docs", 0},
+		{`<a href="/docsearch">`, "/docs", -1},
+		{`<a href="/docs">`, "", -1},
+	}
+	for _, tt := range tests {
+		if got := find([]byte(tt.contents), tt.link); got != tt.want {
+			t.Errorf("find(%q, %q) = %d, want %d", tt.contents, tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	contents := []byte("first\nsécond /docs\n\nfourth")
+	tests := []struct {
+		offset, line, column int
+	}{
+		{0, 1, 1},
+		{4, 1, 5},
+		{6, 2, 1},
+		{14, 2, 8}, // é is two bytes but one column
+		{20, 3, 1},
+		{21, 4, 1},
+	}
+	for _, tt := range tests {
+		if line, column := position(contents, tt.offset); line != tt.line || column != tt.column {
+			t.Errorf("position(%d) = %d:%d, want %d:%d", tt.offset, line, column, tt.line, tt.column)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "setup.md")
+	if err := os.WriteFile(source, []byte("# Setup\n\nSee [the guide](guide.html) or [install](#install).\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := New([]config.SourceMapping{{Path: "/docs/*.html", File: filepath.Join(dir, "*.md")}})
+
+	tests := []struct {
+		result linkpatrol.Result
+		want   Location
+		found  bool
+	}{
+		{linkpatrol.Result{URL: "https://site.example/docs/guide.html", Referrer: "https://site.example/docs/setup.html"}, Location{File: source, Line: 3, Column: 17}, true},
+		{linkpatrol.Result{URL: "https://site.example/docs/setup.html#install", Referrer: "https://site.example/docs/setup.html"}, Location{File: source, Line: 3, Column: 42}, true},
+		{linkpatrol.Result{URL: "https://site.example/docs/gone.html", Referrer: "https://site.example/docs/setup.html"}, Location{File: source}, true},
+		{linkpatrol.Result{URL: "https://site.example/docs/guide.html", Referrer: "https://site.example/docs/missing.html"}, Location{}, false},
+		{linkpatrol.Result{URL: "https://site.example/docs/guide.html", Referrer: "https://site.example/blog/"}, Location{}, false},
+		{linkpatrol.Result{URL: "https://site.example/docs/guide.html"}, Location{}, false},
+	}
+	for _, tt := range tests {
+		location, found := m.Locate(tt.result)
+		if location != tt.want || found != tt.found {
+			t.Errorf("Locate(%s on %s) = %+v, %v, want %+v, %v", tt.result.URL, tt.result.Referrer, location, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		location  Location
+		text, url string
+	}{
+		{Location{File: "docs/setup.md", Line: 3, Column: 21}, "docs/setup.md:3:21", "https://github.com/o/r/blob/abc/docs/setup.md#L3"},
+		{Location{File: "./docs/setup.md", Line: 3}, "./docs/setup.md:3", "https://github.com/o/r/blob/abc/docs/setup.md#L3"},
+		{Location{File: "docs/setup.md"}, "docs/setup.md", "https://github.com/o/r/blob/abc/docs/setup.md"},
+	}
+	for _, tt := range tests {
+		if got := tt.location.String(); got != tt.text {
+			t.Errorf("String() = %q, want %q", got, tt.text)
+		}
+		if got := tt.location.URL("https://github.com/o/r/blob/abc/"); got != tt.url {
+			t.Errorf("URL() = %q, want %q", got, tt.url)
+		}
+		if got := tt.location.URL(""); got != "" {
+			t.Errorf("URL without a base = %q", got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package suppress
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sirprodigle/linkpatrol/internal/glob"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// Rule mutes failures of the links matching Pattern, optionally only on the
+// pages matching Pages. Patterns are URLs where * matches any run of characters.
+type Rule struct {
+	Pattern string `yaml:"pattern"`
+	Pages   string `yaml:"pages"`
+	Reason  string `yaml:"reason"`
+	Owner   string `yaml:"owner"`
+	Expires string `yaml:"expires"` // YYYY-MM-DD, the last day the rule applies
+
+	pattern *regexp.Regexp
+	pages   *regexp.Regexp
+	expires time.Time
+}
+
+// List is a set of suppression rules loaded from a file
+type List struct {
+	rules []Rule
+}
+
+// Load reads the suppression rules in path. Rules that expired before now
+// are left out of the list and returned separately so they can be reported.
+// A missing file gives a nil list.
+func Load(path string, now time.Time) (list *List, expired []Rule, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading suppressions: %w", err)
+	}
+
+	var rules []Rule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, nil, fmt.Errorf("parsing suppressions %s: %w", path, err)
+	}
+
+	list = &List{}
+	for i, rule := range rules {
+		if rule.Pattern == "" || rule.Reason == "" {
+			return nil, nil, fmt.Errorf("suppression %d in %s needs a pattern and a reason", i+1, path)
+		}
+		rule.pattern = glob.Compile(rule.Pattern)
+		if rule.Pages != "" {
+			rule.pages = glob.Compile(rule.Pages)
+		}
+		if rule.Expires != "" {
+			day, err := time.ParseInLocation("2006-01-02", rule.Expires, now.Location())
+			if err != nil {
+				return nil, nil, fmt.Errorf("suppression %q in %s: expires must be YYYY-MM-DD", rule.Pattern, path)
+			}
+			// The rule covers the whole of its last day
+			rule.expires = day.AddDate(0, 0, 1)
+			if !now.Before(rule.expires) {
+				expired = append(expired, rule)
+				continue
+			}
+		}
+		list.rules = append(list.rules, rule)
+	}
+	return list, expired, nil
+}
+
+// Match returns the first rule that applies to a result
+func (l *List) Match(result linkpatrol.Result) (Rule, bool) {
+	for _, rule := range l.rules {
+		if !rule.pattern.MatchString(result.URL) {
+			continue
+		}
+		if rule.pages != nil && !rule.pages.MatchString(result.Referrer) {
+			continue
+		}
+		return rule, true
+	}
+	return Rule{}, false
+}
+
+// Describe explains the rule for reports: its reason, owner and expiry
+func (r Rule) Describe() string {
+	var details []string
+	if r.Owner != "" {
+		details = append(details, "owner "+r.Owner)
+	}
+	if r.Expires != "" {
+		details = append(details, "until "+r.Expires)
+	}
+	if len(details) == 0 {
+		return r.Reason
+	}
+	return fmt.Sprintf("%s (%s)", r.Reason, strings.Join(details, ", "))
+}
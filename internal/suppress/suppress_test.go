@@ -0,0 +1,96 @@
+package suppress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+func writeRules(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ".linkpatrolignore")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExpiresAtTheEndOfTheLastDay(t *testing.T) {
+	path := writeRules(t, `
+- pattern: https://example.com/old
+  reason: migrating
+  expires: 2026-03-31
+`)
+	lastDay := time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC)
+	list, expired, err := Load(path, lastDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Error("rule expired during its last day")
+	}
+	if _, ok := list.Match(linkpatrol.Result{URL: "https://example.com/old"}); !ok {
+		t.Error("rule doesn't apply on its last day")
+	}
+
+	list, expired, err = Load(path, lastDay.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Pattern != "https://example.com/old" {
+		t.Errorf("expired = %+v, want the rule the day after", expired)
+	}
+	if _, ok := list.Match(linkpatrol.Result{URL: "https://example.com/old"}); ok {
+		t.Error("expired rule still applies")
+	}
+}
+
+func TestMatchPagesScope(t *testing.T) {
+	path := writeRules(t, `
+- pattern: https://flaky.example/*
+  pages: https://site.example/blog/*
+  reason: flaky on old posts
+`)
+	list, _, err := Load(path, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url, referrer string
+		want          bool
+	}{
+		{"https://flaky.example/x", "https://site.example/blog/2020", true},
+		{"https://flaky.example/x", "https://site.example/docs", false},
+		{"https://flaky.example/x", "", false}, // a start page isn't on any page
+		{"https://other.example/x", "https://site.example/blog/2020", false},
+	}
+	for _, tt := range tests {
+		_, got := list.Match(linkpatrol.Result{URL: tt.url, Referrer: tt.referrer})
+		if got != tt.want {
+			t.Errorf("Match(%s on %q) = %v, want %v", tt.url, tt.referrer, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsIncompleteRules(t *testing.T) {
+	for _, yaml := range []string{
+		"- pattern: https://example.com/\n",
+		"- reason: no pattern\n",
+		"- pattern: https://example.com/\n  reason: r\n  expires: 31/03/2026\n",
+	} {
+		if _, _, err := Load(writeRules(t, yaml), time.Now()); err == nil {
+			t.Errorf("loaded %q", yaml)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	list, expired, err := Load(filepath.Join(t.TempDir(), "missing"), time.Now())
+	if list != nil || expired != nil || err != nil {
+		t.Errorf("Load = %v, %v, %v; want nothing", list, expired, err)
+	}
+}
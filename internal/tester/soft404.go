@@ -0,0 +1,263 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// Soft404MaxBytes caps how much of a page is read to fingerprint it
+	Soft404MaxBytes = 1 << 20
+	// soft404Similarity is the share of word shingles two pages must have in common to count as the same page
+	soft404Similarity = 0.9
+	// soft404TitleSimilarity is the lower share of shingles that is enough for a page with the not-found page's title and size
+	soft404TitleSimilarity = 0.5
+	// soft404SizeTolerance is how far apart, relatively, two page sizes may be for a title match to count
+	soft404SizeTolerance = 0.1
+	// soft404SizeSlack is an absolute size difference that is always tolerated, for small pages
+	soft404SizeSlack = 512
+)
+
+// soft404Error reports a page that answered 2xx but looks like a "page not found" page
+type soft404Error struct {
+	reason string
+}
+
+func (e *soft404Error) Error() string {
+	return "soft 404: " + e.reason
+}
+
+// pageFingerprint summarises a page so two responses can be compared cheaply
+type pageFingerprint struct {
+	title    string
+	size     int
+	shingles map[uint64]struct{}
+}
+
+type soft404Baseline struct {
+	ready       chan struct{}
+	fingerprint *pageFingerprint // nil when the host answers unknown paths with a real error
+}
+
+// Soft404Detector spots pages that return 200 with a "not found" template. For
+// each host it requests a random path that can't exist and fingerprints the
+// response; link targets that match that fingerprint, or contain one of the
+// configured phrases, are soft 404s.
+type Soft404Detector struct {
+	client     *http.Client
+	workerPool DomainLimiterProvider
+	phrases    []string
+
+	mu        sync.Mutex
+	baselines map[string]*soft404Baseline // keyed by scheme://host
+}
+
+func NewSoft404Detector(client *http.Client, workerPool DomainLimiterProvider, phrases []string) *Soft404Detector {
+	lowered := make([]string, 0, len(phrases))
+	for _, phrase := range phrases {
+		if phrase = strings.TrimSpace(phrase); phrase != "" {
+			lowered = append(lowered, strings.ToLower(phrase))
+		}
+	}
+	return &Soft404Detector{
+		client:     client,
+		workerPool: workerPool,
+		phrases:    lowered,
+		baselines:  make(map[string]*soft404Baseline, 100),
+	}
+}
+
+// Check reports whether a successful response with body, of which at most
+// Soft404MaxBytes are looked at, is a soft 404, along with the reason
+func (d *Soft404Detector) Check(ctx context.Context, target *url.URL, contentType string, body []byte) (bool, string) {
+	if !isHTML(contentType) {
+		return false, ""
+	}
+	if len(body) > Soft404MaxBytes {
+		body = body[:Soft404MaxBytes]
+	}
+
+	lowered := bytes.ToLower(body)
+	for _, phrase := range d.phrases {
+		if bytes.Contains(lowered, []byte(phrase)) {
+			return true, fmt.Sprintf("page contains %q", phrase)
+		}
+	}
+
+	// A site's home page is often where its unknown paths end up, so it can't be judged against them
+	if target.Path == "" || target.Path == "/" {
+		return false, ""
+	}
+
+	baseline := d.baseline(ctx, target)
+	if baseline == nil {
+		return false, ""
+	}
+	page := fingerprint(bytes.ReplaceAll(body, []byte(target.Path), nil))
+	similar := similarity(page.shingles, baseline.shingles)
+	// Sites often give every page the same title, so a title match alone says little
+	if baseline.title != "" && page.title == baseline.title && sizesClose(page.size, baseline.size) && similar >= soft404TitleSimilarity {
+		return true, fmt.Sprintf("same title and size as the not-found page (%q)", page.title)
+	}
+	if similar >= soft404Similarity {
+		return true, "content matches the not-found page"
+	}
+	return false, ""
+}
+
+// baseline returns the fingerprint of the host's not-found page, probing the
+// host the first time it's seen. It returns nil if the host sends real errors.
+func (d *Soft404Detector) baseline(ctx context.Context, target *url.URL) *pageFingerprint {
+	key := target.Scheme + "://" + target.Host
+
+	d.mu.Lock()
+	entry, exists := d.baselines[key]
+	if !exists {
+		entry = &soft404Baseline{ready: make(chan struct{})}
+		d.baselines[key] = entry
+	}
+	d.mu.Unlock()
+
+	if !exists {
+		entry.fingerprint = d.probe(ctx, key)
+		close(entry.ready)
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.fingerprint
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// probe requests a random path on origin and fingerprints the response if it claims success
+func (d *Soft404Detector) probe(ctx context.Context, origin string) *pageFingerprint {
+	token := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return nil
+	}
+	probeURL := origin + "/linkpatrol-soft404-probe-" + hex.EncodeToString(token)
+
+	u, err := url.Parse(probeURL)
+	if err != nil {
+		return nil
+	}
+	if err := d.workerPool.GetDomainLimiter(u.Host).Wait(ctx); err != nil {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", probeURL, nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 || !isHTML(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, Soft404MaxBytes))
+	if err != nil {
+		return nil
+	}
+	// Not-found pages often echo the requested path, so leave it out of the fingerprint
+	return fingerprint(bytes.ReplaceAll(body, []byte(u.Path), nil))
+}
+
+// fingerprint extracts the title, size and word shingles of an HTML page
+func fingerprint(body []byte) *pageFingerprint {
+	var title strings.Builder
+	var words []string
+	inTitle := false
+	skip := 0 // depth inside <script> and <style>, whose text isn't page content
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "title":
+				inTitle = true
+			case "script", "style":
+				skip++
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "title":
+				inTitle = false
+			case "script", "style":
+				skip = max(0, skip-1)
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := string(z.Text())
+			if inTitle {
+				title.WriteString(text)
+			}
+			words = append(words, strings.Fields(strings.ToLower(text))...)
+		}
+	}
+
+	return &pageFingerprint{
+		title:    strings.TrimSpace(title.String()),
+		size:     len(body),
+		shingles: shingles(words, 3),
+	}
+}
+
+// shingles hashes every run of n consecutive words
+func shingles(words []string, n int) map[uint64]struct{} {
+	set := make(map[uint64]struct{}, len(words))
+	if len(words) < n {
+		n = len(words)
+	}
+	for i := 0; i+n <= len(words) && n > 0; i++ {
+		h := fnv.New64a()
+		for _, word := range words[i : i+n] {
+			h.Write([]byte(word))
+			h.Write([]byte{0})
+		}
+		set[h.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// similarity returns the Jaccard index of two shingle sets
+func similarity(a, b map[uint64]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func sizesClose(a, b int) bool {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= soft404SizeSlack || float64(diff) <= soft404SizeTolerance*float64(max(a, b))
+}
@@ -0,0 +1,196 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// unlimited lets every request through straight away
+type unlimited struct{}
+
+func (unlimited) GetDomainLimiter(string) *rate.Limiter { return rate.NewLimiter(rate.Inf, 0) }
+func (unlimited) RateLimitWaited(string, time.Duration) {}
+
+const notFoundTemplate = `<html><head><title>Oops</title></head><body>
+<nav>Home Blog About Contact</nav>
+<p>We looked everywhere for %s but that page has gone missing. Try the search box or head back home.</p>
+</body></html>`
+
+const articlePage = `<html><head><title>Release notes</title></head><body>
+<nav>Home Blog About Contact</nav>
+<h1>Version 2 is out</h1>
+<p>This release adds streaming reports, faster crawling of large sites and a new configuration format with per host limits.</p>
+</body></html>`
+
+// soft404Site serves the article at /article and the not-found template with a 200 everywhere else
+func soft404Site(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/article" {
+			fmt.Fprint(w, articlePage)
+			return
+		}
+		fmt.Fprintf(w, notFoundTemplate, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mustParse(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSoft404Classification(t *testing.T) {
+	server := soft404Site(t)
+	d := NewSoft404Detector(server.Client(), unlimited{}, []string{"  Page Not Found "})
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"not-found template", "/missing", "text/html", fmt.Sprintf(notFoundTemplate, "/missing"), true},
+		{"real page", "/article", "text/html", articlePage, false},
+		{"configured phrase", "/gone", "text/html", "<h1>PAGE NOT FOUND</h1>", true},
+		{"home page is never matched against the template", "/", "text/html", fmt.Sprintf(notFoundTemplate, "/"), false},
+		{"not HTML", "/data.json", "application/json", fmt.Sprintf(notFoundTemplate, "/data.json"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := d.Check(context.Background(), mustParse(t, server.URL+tt.path), tt.contentType, []byte(tt.body))
+			if got != tt.want {
+				t.Errorf("Check = %v (%s), want %v", got, reason, tt.want)
+			}
+			if got && reason == "" {
+				t.Error("soft 404 reported without a reason")
+			}
+		})
+	}
+}
+
+func TestSoft404SharedTitleAloneIsNotEnough(t *testing.T) {
+	// Every page on the site, the not-found page included, has the same title and layout
+	const page = `<html><head><title>Acme Docs</title></head><body>
+<nav>Home Guides Reference Support</nav>
+<p>%s</p>
+</body></html>`
+	notFound := "We looked everywhere for that page but it has gone missing. Try the search box or head back home."
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, page, notFound)
+	}))
+	defer server.Close()
+	d := NewSoft404Detector(server.Client(), unlimited{}, nil)
+
+	tests := []struct {
+		path, text string
+		want       bool
+	}{
+		{"/guides/install", "Download the installer for your platform, run it and sign in with your company account.", false},
+		{"/reference/api", "Every endpoint takes a JSON body and answers with the created resource or an error object.", false},
+		{"/missing", notFound, true},
+		// The same page with a request id added still counts
+		{"/moved", notFound + " Request 3f9a2c.", true},
+	}
+	for _, tt := range tests {
+		got, reason := d.Check(context.Background(), mustParse(t, server.URL+tt.path), "text/html", []byte(fmt.Sprintf(page, tt.text)))
+		if got != tt.want {
+			t.Errorf("%s: Check = %v (%s), want %v", tt.path, got, reason, tt.want)
+		}
+	}
+}
+
+func TestSoft404HostWithRealErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	d := NewSoft404Detector(server.Client(), unlimited{}, nil)
+
+	// Without a baseline only phrases can flag a page, however it looks
+	got, reason := d.Check(context.Background(), mustParse(t, server.URL+"/missing"), "text/html", []byte(fmt.Sprintf(notFoundTemplate, "/missing")))
+	if got {
+		t.Errorf("flagged a page on a host that sends real 404s: %s", reason)
+	}
+}
+
+func TestSoft404ProbesEachHostOnce(t *testing.T) {
+	probes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "linkpatrol-soft404-probe-") {
+			probes++
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, notFoundTemplate, r.URL.Path)
+	}))
+	defer server.Close()
+	d := NewSoft404Detector(server.Client(), unlimited{}, nil)
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		d.Check(context.Background(), mustParse(t, server.URL+path), "text/html", []byte(fmt.Sprintf(notFoundTemplate, path)))
+	}
+	if probes != 1 {
+		t.Errorf("probed the host %d times, want 1", probes)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fp := fingerprint([]byte(`<title> Hello </title><style>body { color: red }</style><p>one two three four</p><script>var x = 1</script>`))
+	if fp.title != "Hello" {
+		t.Errorf("title = %q, want Hello", fp.title)
+	}
+	// "hello" plus the four paragraph words; style and script text are left out
+	if want := len(shingles([]string{"hello", "one", "two", "three", "four"}, 3)); len(fp.shingles) != want {
+		t.Errorf("got %d shingles, want %d", len(fp.shingles), want)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	words := strings.Fields("the quick brown fox jumps over the lazy dog")
+	a := shingles(words, 3)
+	if got := similarity(a, a); got != 1 {
+		t.Errorf("similarity with itself = %v, want 1", got)
+	}
+	if got := similarity(a, shingles(strings.Fields("an entirely different sentence about cats"), 3)); got != 0 {
+		t.Errorf("similarity of unrelated text = %v, want 0", got)
+	}
+	if got := similarity(a, nil); got != 0 {
+		t.Errorf("similarity with an empty page = %v, want 0", got)
+	}
+	// Fewer words than the shingle size still make one shingle
+	if got := len(shingles([]string{"short"}, 3)); got != 1 {
+		t.Errorf("got %d shingles for one word, want 1", got)
+	}
+}
+
+func TestSizesClose(t *testing.T) {
+	tests := []struct {
+		a, b int
+		want bool
+	}{
+		{100, 600, true},      // within the absolute slack
+		{100, 700, false},     // beyond it, and far apart relatively
+		{10000, 10900, true},  // within 10%
+		{10000, 12000, false}, // 20% apart
+		{12000, 10000, false}, // order doesn't matter
+	}
+	for _, tt := range tests {
+		if got := sizesClose(tt.a, tt.b); got != tt.want {
+			t.Errorf("sizesClose(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tester
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 
@@ -24,26 +26,56 @@ var bannedDomains = []string{
 type Tester struct {
 	logger      *logger.Logger
 	cache       *cache.ResultsCache
-	toTestChan  <-chan walker.WalkerRequest
+	pages       *cache.PageCache
 	resultsChan chan<- cache.CacheEntry
 	workerPool  DomainLimiterProvider
 	activeCount *atomic.Int32
 	client      *http.Client
+	targetHost  string
+
+	externalAnchors ExternalAnchorOptions
+	soft404         *Soft404Detector
+}
+
+// ExternalAnchorOptions controls checking the fragments of links to other sites
+type ExternalAnchorOptions struct {
+	Enabled     bool
+	MaxPageSize int64    // most bytes downloaded from a page when looking for its anchors
+	IgnoreHosts []string // hosts whose anchors are generated by JavaScript, including their subdomains
+}
+
+func (o ExternalAnchorOptions) ignores(host string) bool {
+	for _, ignored := range o.IgnoreHosts {
+		if host == ignored || strings.HasSuffix(host, "."+ignored) {
+			return true
+		}
+	}
+	return false
 }
 
 type DomainLimiterProvider interface {
 	GetDomainLimiter(domain string) *rate.Limiter
+	// RateLimitWaited reports that a request to domain was held back by its rate limit
+	RateLimitWaited(domain string, waited time.Duration)
 }
 
-func NewTester(cache *cache.ResultsCache, results <-chan walker.WalkerRequest, workerPool DomainLimiterProvider, verbose bool, activeCount *atomic.Int32, client *http.Client, resultsChan chan<- cache.CacheEntry) *Tester {
+func NewTester(cache *cache.ResultsCache, pages *cache.PageCache, workerPool DomainLimiterProvider, verbose bool, activeCount *atomic.Int32, client *http.Client, resultsChan chan<- cache.CacheEntry, targetBaseUrl string, externalAnchors ExternalAnchorOptions, soft404 *Soft404Detector) *Tester {
+	var targetHost string
+	if u, err := url.Parse(targetBaseUrl); err == nil {
+		targetHost = u.Host
+	}
 	return &Tester{
 		logger:      logger.New(verbose),
 		cache:       cache,
-		toTestChan:  results,
+		pages:       pages,
 		workerPool:  workerPool,
 		activeCount: activeCount,
 		client:      client,
 		resultsChan: resultsChan,
+		targetHost:  targetHost,
+
+		externalAnchors: externalAnchors,
+		soft404:         soft404,
 	}
 }
 
@@ -62,29 +94,15 @@ func (t *Tester) Test(ctx context.Context, requestData walker.WalkerRequest) {
 		if strings.Contains(requestData.Path, banned) {
 			t.logger.Debug("Skipping url: %s, it's a banned domain", requestData.Path)
 			t.resultsChan <- cache.CacheEntry{
-				URL:    requestData.Path,
-				Status: cache.Ignore,
-				Error:  "Banned domain",
+				URL:      requestData.Path,
+				Status:   cache.Ignore,
+				Error:    "Banned domain",
+				Referrer: requestData.BasePath,
 			}
 			return
 		}
 	}
 
-	// Handle fragment URLs (like #section) - check if they exist on the original page
-	if strings.HasPrefix(requestData.Path, "#") {
-		if requestData.BasePath != "" {
-			t.checkFragmentOnPage(ctx, requestData.Path, requestData.BasePath)
-		} else {
-			t.resultsChan <- cache.CacheEntry{
-				URL:    requestData.Path,
-				Status: cache.Dead,
-				Error:  "Fragment URL with no base page to check against",
-			}
-			t.logger.Debug("❌ %s -> DEAD (no base page)", requestData.Path)
-		}
-		return
-	}
-
 	// Resolve relative URLs using BasePath
 	resolvedURL := requestData.Path
 	if parsed, err := url.Parse(requestData.Path); err == nil && parsed.Host == "" && requestData.BasePath != "" {
@@ -104,59 +122,114 @@ func (t *Tester) Test(ctx context.Context, requestData walker.WalkerRequest) {
 	t.logger.Debug("🟦 Testing %s", resolvedURL)
 
 	// Check if the url is valid
-	if _, err := url.Parse(resolvedURL); err != nil {
+	parsed, err := url.Parse(resolvedURL)
+	if err != nil {
 		t.resultsChan <- cache.CacheEntry{
-			URL:    resolvedURL,
-			Status: cache.Dead,
-			Error:  err.Error(),
+			URL:      resolvedURL,
+			Status:   cache.Dead,
+			Error:    err.Error(),
+			Referrer: requestData.BasePath,
 		}
 		t.logger.Debug("❌ %s -> DEAD (invalid URL: %v)", resolvedURL, err)
 		return
 	}
+
+	// Fragments of pages on the target site are checked against the page's anchors
+	if parsed.Fragment != "" && parsed.Host == t.targetHost {
+		t.checkFragment(ctx, parsed, walker.MaxPageBytes, requestData.BasePath, nil)
+		return
+	}
+
+	// Keep the page from the check when its anchors are needed, so it isn't downloaded twice
+	checkAnchor := parsed.Fragment != "" && t.externalAnchors.Enabled && !t.externalAnchors.ignores(parsed.Hostname())
+
 	// Check if the URL is live
-	finalURL, err := t.PingUrlWithFallback(ctx, resolvedURL)
+	finalURL, redirects, page, err := t.PingUrlWithFallback(ctx, resolvedURL, checkAnchor)
 	if err != nil {
+		// The run was interrupted, so don't report the link as broken
+		if ctx.Err() != nil {
+			t.logger.Debug("🛑 %s -> ABANDONED (%v)", finalURL, err)
+			return
+		}
+		var soft404 *soft404Error
+		if errors.As(err, &soft404) {
+			t.resultsChan <- cache.CacheEntry{
+				URL:       finalURL,
+				Status:    cache.Soft404,
+				Error:     soft404.reason,
+				Referrer:  requestData.BasePath,
+				Redirects: redirects,
+			}
+			t.logger.Debug("👻 %s -> SOFT 404 (%s)", finalURL, soft404.reason)
+			return
+		}
 		// check if http timeout error
 		if isTimeout, err := isTimeoutError(err); isTimeout {
 			t.resultsChan <- cache.CacheEntry{
-				URL:    finalURL,
-				Status: cache.Timeout,
-				Error:  err.Error(),
+				URL:       finalURL,
+				Status:    cache.Timeout,
+				Error:     err.Error(),
+				Referrer:  requestData.BasePath,
+				Redirects: redirects,
 			}
 			t.logger.Debug("⏰ %s -> TIMEOUT (%v)", finalURL, err)
 			return
 		}
 		if isBot, err := isBotError(err); isBot {
 			t.resultsChan <- cache.CacheEntry{
-				URL:    finalURL,
-				Status: cache.Bot,
-				Error:  err.Error(),
+				URL:       finalURL,
+				Status:    cache.Bot,
+				Error:     err.Error(),
+				Referrer:  requestData.BasePath,
+				Redirects: redirects,
 			}
 			t.logger.Debug("🤖 %s -> BOT DETECTED (%v)", finalURL, err)
 			return
 		}
 		t.resultsChan <- cache.CacheEntry{
-			URL:    finalURL,
-			Status: cache.Dead,
-			Error:  err.Error(),
+			URL:       finalURL,
+			Status:    cache.Dead,
+			Error:     err.Error(),
+			Referrer:  requestData.BasePath,
+			Redirects: redirects,
 		}
 		t.logger.Debug("❌ %s -> DEAD (%v)", finalURL, err)
 		return
 	}
+
+	// Optionally make sure the fragment exists on the other site's page too
+	if checkAnchor {
+		if final, err := url.Parse(finalURL); err == nil {
+			t.checkFragment(ctx, final, t.externalAnchors.MaxPageSize, requestData.BasePath, page)
+			return
+		}
+	}
+
 	t.resultsChan <- cache.CacheEntry{
-		URL:    finalURL,
-		Status: cache.Live,
-		Error:  "",
+		URL:       finalURL,
+		Status:    cache.Live,
+		Error:     "",
+		Referrer:  requestData.BasePath,
+		Redirects: redirects,
 	}
 	t.logger.Debug("✅ %s -> LIVE", finalURL)
 
 }
 
-func (t *Tester) PingUrlWithFallback(ctx context.Context, path string) (string, error) {
+// PingUrlWithFallback checks path, retrying over HTTP if HTTPS fails, and
+// returns the URL that was used along with any redirects it followed and,
+// if readPage is set, the page it loaded
+func (t *Tester) PingUrlWithFallback(ctx context.Context, path string, readPage bool) (string, []cache.Redirect, *cache.Page, error) {
 	// First try the URL as-is (likely HTTPS)
-	err := t.PingUrl(ctx, path)
+	redirects, page, err := t.PingUrl(ctx, path, readPage)
 	if err == nil {
-		return path, nil
+		return path, redirects, page, nil
+	}
+
+	// The server answered, it just served a not-found page, so HTTP won't do better
+	var soft404 *soft404Error
+	if errors.As(err, &soft404) {
+		return path, redirects, nil, err
 	}
 
 	// If it's an HTTPS URL and failed, try HTTP fallback
@@ -164,24 +237,27 @@ func (t *Tester) PingUrlWithFallback(ctx context.Context, path string) (string,
 		httpURL := strings.Replace(path, "https://", "http://", 1)
 		t.logger.Debug("🔄 HTTPS failed, trying HTTP fallback: %s", httpURL)
 
-		httpErr := t.PingUrl(ctx, httpURL)
+		httpRedirects, httpPage, httpErr := t.PingUrl(ctx, httpURL, readPage)
 		if httpErr == nil {
-			return httpURL, nil
+			return httpURL, httpRedirects, httpPage, nil
 		}
 
 		// Return the original HTTPS error since HTTP also failed
-		return path, err
+		return path, redirects, nil, err
 	}
 
 	// Not an HTTPS URL or some other issue, return original error
-	return path, err
+	return path, redirects, nil, err
 }
 
-func (t *Tester) PingUrl(ctx context.Context, path string) error {
+// PingUrl checks path is reachable, returning the redirects it followed. If
+// readPage is set, it also returns the page's anchors for the page cache,
+// reading at most the external anchor page size.
+func (t *Tester) PingUrl(ctx context.Context, path string, readPage bool) ([]cache.Redirect, *cache.Page, error) {
 	// Extract domain for rate limiting
 	u, err := url.Parse(path)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Get domain-specific rate limiter
@@ -190,38 +266,61 @@ func (t *Tester) PingUrl(ctx context.Context, path string) error {
 	// Wait for rate limiter permit
 	if !domainLimiter.Allow() {
 		t.logger.Progress("Waiting for rate limit permit for domain: %s", u.Host)
+		waitStart := time.Now()
 		if err := domainLimiter.Wait(ctx); err != nil {
-			return err
+			return nil, nil, err
 		}
+		t.workerPool.RateLimitWaited(u.Host, time.Since(waitStart))
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	// Fake a real browser request
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Upgrade-Insecure-Requests", "1")
-
 	resp, err := t.client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	redirects := walker.RedirectChain(resp)
 
 	if resp.StatusCode >= 400 {
-		return &url.Error{
+		return redirects, nil, &url.Error{
 			Op:  "GET",
 			URL: path,
 			Err: fmt.Errorf("HTTP %d", resp.StatusCode),
 		}
 	}
+	if t.soft404 == nil && !readPage {
+		return redirects, nil, nil
+	}
 
-	return nil
+	// One read of the body serves both the soft 404 check and the anchor check
+	contentType := resp.Header.Get("Content-Type")
+	var body []byte
+	var truncated bool
+	var readErr error
+	if isHTML(contentType) {
+		limit := int64(Soft404MaxBytes)
+		if readPage {
+			limit = t.externalAnchors.MaxPageSize
+			if t.soft404 != nil && limit > 0 {
+				limit = max(limit, Soft404MaxBytes)
+			}
+		}
+		body, truncated, readErr = readBody(resp.Body, limit)
+	}
+
+	if t.soft404 != nil {
+		if isSoft404, reason := t.soft404.Check(ctx, u, contentType, body); isSoft404 {
+			return redirects, nil, &soft404Error{reason: reason}
+		}
+	}
+	if !readPage {
+		return redirects, nil, nil
+	}
+	return redirects, newPage(resp, body, truncated, readErr, t.externalAnchors.MaxPageSize), nil
 }
 
 func (t *Tester) TestEmail(ctx context.Context, path string) error {
@@ -239,88 +338,153 @@ func (t *Tester) TestEmail(ctx context.Context, path string) error {
 	return nil
 }
 
-// checkFragmentOnPage checks if a fragment (like #section) exists on the given page
-func (t *Tester) checkFragmentOnPage(ctx context.Context, fragment, basePage string) {
-	// Remove the # from fragment
-	targetId := strings.TrimPrefix(fragment, "#")
-
-	// If it's just "#", it's always valid (top of page)
-	if targetId == "" {
-		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Live,
-			Error:  "",
+// checkFragment checks that the fragment of target exists on its page, using
+// the page cache so each page is fetched at most once per run. If the page has
+// to be fetched, at most maxBytes are read from it (0 = walker.MaxPageBytes).
+// loaded, if set, is the page already downloaded, and referrer is the page the
+// link was found on.
+func (t *Tester) checkFragment(ctx context.Context, target *url.URL, maxBytes int64, referrer string, loaded *cache.Page) {
+	if maxBytes <= 0 {
+		maxBytes = walker.MaxPageBytes
+	}
+	// Keyed and reported like the walker does, by the page URL and the unescaped fragment
+	pageURL, fragment, _ := strings.Cut(target.String(), "#")
+	fragment = walker.UnescapeFragment(fragment)
+	key := pageURL + "#" + fragment
+
+	cached, err := t.pages.Load(ctx, pageURL, func() *cache.Page {
+		if loaded != nil {
+			return loaded
 		}
-		t.logger.Debug("✅ %s -> LIVE (top of page)", fragment)
+		return t.fetchPage(ctx, pageURL, maxBytes)
+	})
+	if err != nil {
+		t.logger.Debug("🛑 %s -> ABANDONED (%v)", key, err)
 		return
 	}
 
-	// Fetch the page content
-	resp, err := t.client.Get(basePage)
-	if err != nil {
+	switch {
+	case cached.Error != "":
 		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Dead,
-			Error:  fmt.Sprintf("Could not fetch base page to check fragment: %v", err),
+			URL:      key,
+			Status:   cache.Dead,
+			Error:    fmt.Sprintf("Could not fetch page to check fragment: %s", cached.Error),
+			Referrer: referrer,
 		}
-		t.logger.Debug("❌ %s -> DEAD (could not fetch base page)", fragment)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
+		t.logger.Debug("❌ %s -> DEAD (could not fetch page)", key)
+	case cached.StatusCode >= 400:
 		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Dead,
-			Error:  fmt.Sprintf("Base page returned HTTP %d", resp.StatusCode),
+			URL:      key,
+			Status:   cache.Dead,
+			Error:    fmt.Sprintf("Page returned HTTP %d", cached.StatusCode),
+			Referrer: referrer,
 		}
-		t.logger.Debug("❌ %s -> DEAD (base page HTTP %d)", fragment, resp.StatusCode)
-		return
+		t.logger.Debug("❌ %s -> DEAD (page HTTP %d)", key, cached.StatusCode)
+	case walker.HasAnchor(cached.Anchors, fragment):
+		t.resultsChan <- cache.CacheEntry{
+			URL:      key,
+			Status:   cache.Live,
+			Error:    "",
+			Referrer: referrer,
+		}
+		t.logger.Debug("✅ %s -> LIVE (anchor found)", key)
+	case !isHTML(cached.ContentType):
+		// PDFs and the like interpret fragments themselves, so there's nothing to check
+		t.resultsChan <- cache.CacheEntry{
+			URL:      key,
+			Status:   cache.Live,
+			Error:    "",
+			Referrer: referrer,
+		}
+		t.logger.Debug("✅ %s -> LIVE (not HTML, anchor not checked)", key)
+	case cached.Truncated:
+		t.resultsChan <- cache.CacheEntry{
+			URL:      key,
+			Status:   cache.Live,
+			Error:    fmt.Sprintf("Anchor not verified: page is larger than %d bytes", maxBytes),
+			Referrer: referrer,
+		}
+		t.logger.Debug("✅ %s -> LIVE (page too large to verify anchor)", key)
+	default:
+		t.resultsChan <- cache.CacheEntry{
+			URL:      key,
+			Status:   cache.MissingAnchor,
+			Error:    fmt.Sprintf("No element with id or name '%s' on page %s", fragment, pageURL),
+			Referrer: referrer,
+		}
+		t.logger.Debug("⚓ %s -> MISSING ANCHOR", key)
 	}
+}
 
-	// Read page content
-	body, err := io.ReadAll(resp.Body)
+// fetchPage downloads a page and collects its fragment targets for the page
+// cache, reading at most maxBytes of the body
+func (t *Tester) fetchPage(ctx context.Context, pageURL string, maxBytes int64) *cache.Page {
+	u, err := url.Parse(pageURL)
 	if err != nil {
-		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Dead,
-			Error:  fmt.Sprintf("Could not read base page: %v", err),
-		}
-		t.logger.Debug("❌ %s -> DEAD (could not read base page)", fragment)
-		return
+		return &cache.Page{Error: err.Error()}
+	}
+	if err := t.workerPool.GetDomainLimiter(u.Host).Wait(ctx); err != nil {
+		return &cache.Page{Error: err.Error()}
 	}
 
-	// Check if the target ID exists in the page
-	pageContent := string(body)
-	idPatterns := []string{
-		fmt.Sprintf(`id="%s"`, targetId),
-		fmt.Sprintf(`id='%s'`, targetId),
-		fmt.Sprintf(`id=%s`, targetId),
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return &cache.Page{Error: err.Error()}
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return &cache.Page{Error: err.Error()}
 	}
+	defer resp.Body.Close()
 
-	found := false
-	for _, pattern := range idPatterns {
-		if strings.Contains(pageContent, pattern) {
-			found = true
-			break
-		}
+	var body []byte
+	var truncated bool
+	if isHTML(resp.Header.Get("Content-Type")) {
+		body, truncated, err = readBody(resp.Body, maxBytes)
 	}
+	return newPage(resp, body, truncated, err, maxBytes)
+}
 
-	if found {
-		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Live,
-			Error:  "",
-		}
-		t.logger.Debug("✅ %s -> LIVE (element found)", fragment)
-	} else {
-		t.resultsChan <- cache.CacheEntry{
-			URL:    fragment,
-			Status: cache.Dead,
-			Error:  fmt.Sprintf("Element with id='%s' not found on page %s", targetId, basePage),
-		}
-		t.logger.Debug("❌ %s -> DEAD (element not found)", fragment)
+// readBody reads at most maxBytes of r (0 = walker.MaxPageBytes), reporting whether there was more
+func readBody(r io.Reader, maxBytes int64) ([]byte, bool, error) {
+	if maxBytes <= 0 {
+		maxBytes = walker.MaxPageBytes
+	}
+	// Read one byte past the limit so we can tell the page was cut short
+	body, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if int64(len(body)) > maxBytes {
+		return body[:maxBytes], true, err
+	}
+	return body, false, err
+}
+
+// newPage builds the page cache entry for a response whose body was read,
+// keeping the anchors from at most maxBytes of it (0 = no limit)
+func newPage(resp *http.Response, body []byte, truncated bool, readErr error, maxBytes int64) *cache.Page {
+	page := &cache.Page{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
 	}
+	if !isHTML(page.ContentType) {
+		return page
+	}
+	if readErr != nil {
+		page.Error = readErr.Error()
+		return page
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		body = body[:maxBytes]
+		truncated = true
+	}
+	page.Truncated = truncated
+	page.Anchors = walker.ExtractAnchors(body)
+	return page
+}
+
+// isHTML reports whether a Content-Type header describes an HTML page. A
+// missing header is treated as HTML, as browsers would sniff it.
+func isHTML(contentType string) bool {
+	return contentType == "" || strings.Contains(contentType, "html")
 }
 
 // isTimeoutError checks if the error is a timeout error
@@ -0,0 +1,63 @@
+package tester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/walker"
+)
+
+func TestReadBodyAlwaysHasALimit(t *testing.T) {
+	body, truncated, err := readBody(strings.NewReader(strings.Repeat("x", walker.MaxPageBytes+10)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != walker.MaxPageBytes || !truncated {
+		t.Errorf("read %d bytes (truncated %v), want the first %d", len(body), truncated, walker.MaxPageBytes)
+	}
+
+	body, truncated, _ = readBody(strings.NewReader("<p>short</p>"), 5)
+	if string(body) != "<p>sh" || !truncated {
+		t.Errorf("read %q (truncated %v), want 5 bytes", body, truncated)
+	}
+}
+
+func TestCheckFragmentUsesThePageTheWalkerLoaded(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	// The walker records the page under its URL, whatever fragment it was reached by
+	pages := cache.NewPageCache()
+	finish := pages.Begin(server.URL + "/menu#drinks")
+	finish(&cache.Page{StatusCode: http.StatusOK, ContentType: "text/html", Anchors: map[string]bool{"café": true}})
+
+	results := make(chan cache.CacheEntry, 10)
+	tester := NewTester(cache.NewResultsCache(results), pages, unlimited{}, false, &atomic.Int32{}, server.Client(), results, server.URL, ExternalAnchorOptions{}, nil)
+
+	tests := []struct {
+		link, url string
+		status    cache.CacheEntryStatus
+	}{
+		{server.URL + "/menu#caf%C3%A9", server.URL + "/menu#café", cache.Live},
+		{server.URL + "/menu#café", server.URL + "/menu#café", cache.Live},
+		{server.URL + "/menu#tea", server.URL + "/menu#tea", cache.MissingAnchor},
+	}
+	for _, tt := range tests {
+		tester.checkFragment(context.Background(), mustParse(t, tt.link), 0, server.URL+"/", nil)
+		result := <-results
+		if result.URL != tt.url || result.Status != tt.status {
+			t.Errorf("%s: recorded %s as %s, want %s as %s", tt.link, result.URL, result.Status, tt.url, tt.status)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("fetched the page %d times, want it taken from the cache", requests)
+	}
+}
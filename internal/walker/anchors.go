@@ -0,0 +1,43 @@
+package walker
+
+import (
+	"bytes"
+
+	"golang.org/x/net/html"
+)
+
+// ExtractAnchors returns every fragment target on an HTML page: the id of any
+// element plus the name of <a> elements
+func ExtractAnchors(body []byte) map[string]bool {
+	anchors := make(map[string]bool)
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// io.EOF or a parse error, either way we have all we can get
+			return anchors
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tagName, hasAttr := z.TagName()
+			isAnchor := string(tagName) == "a"
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if len(val) == 0 {
+					continue
+				}
+				if string(key) == "id" || (isAnchor && string(key) == "name") {
+					anchors[string(val)] = true
+				}
+			}
+		}
+	}
+}
+
+// HasAnchor reports whether fragment points at something on a page with the given anchors
+func HasAnchor(anchors map[string]bool, fragment string) bool {
+	// An empty fragment and "#top" both scroll to the top of the page, even without a matching element
+	if fragment == "" || anchors[fragment] {
+		return true
+	}
+	return fragment == "top"
+}
@@ -0,0 +1,111 @@
+package walker
+
+import (
+	"bytes"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// IgnoreAttribute marks an element whose links, including those of its content, aren't checked
+const IgnoreAttribute = "data-linkpatrol-ignore"
+
+// ignoreNextLineRegex matches the directive that skips the links on the following line, written
+// as an HTML comment or as a Markdown comment: [//]: # (linkpatrol-ignore-next-line)
+var ignoreNextLineRegex = regexp.MustCompile(`<!--\s*linkpatrol-ignore-next-line\s*-->|\[//\]:\s*#\s*\(\s*linkpatrol-ignore-next-line\s*\)`)
+
+// voidElements have no end tag, so an ignored one ends with its start tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// IgnoredRanges are the byte ranges of a page that inline directives cover
+type IgnoredRanges [][2]int
+
+// Covers reports whether the byte at offset is inside an ignored range
+func (r IgnoredRanges) Covers(offset int) bool {
+	for _, span := range r {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractIgnored returns the parts of a page covered by an ignore directive: the line after
+// each ignore-next-line comment and the source of every element with the ignore attribute.
+// Links found there aren't checked, while the same link elsewhere on the page still is.
+func ExtractIgnored(body []byte) IgnoredRanges {
+	var ranges IgnoredRanges
+
+	start := 0
+	directive := false
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		if directive {
+			ranges = append(ranges, [2]int{start, start + len(line)})
+		}
+		directive = ignoreNextLineRegex.Match(line)
+		start += len(line)
+	}
+
+	// Only a fraction of pages use the attribute, so don't tokenize the rest
+	if !bytes.Contains(body, []byte(IgnoreAttribute)) {
+		return ranges
+	}
+
+	var elementTag string
+	elementStart := 0
+	depth := 0  // nesting of elementTag inside the ignored element
+	offset := 0 // where the current token starts; tokens' raw bytes add up to the page
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tokenType := z.Next()
+		tokenStart := offset
+		offset += len(z.Raw())
+		if tokenType == html.ErrorToken {
+			// An element that's never closed runs to the end of the page
+			if depth > 0 {
+				ranges = append(ranges, [2]int{elementStart, len(body)})
+			}
+			return ranges
+		}
+
+		if depth > 0 {
+			tagName, _ := z.TagName()
+			if string(tagName) != elementTag {
+				continue
+			}
+			switch tokenType {
+			case html.StartTagToken:
+				depth++
+			case html.EndTagToken:
+				depth--
+				if depth == 0 {
+					ranges = append(ranges, [2]int{elementStart, offset})
+				}
+			}
+			continue
+		}
+
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+		tagName, hasAttr := z.TagName()
+		for hasAttr {
+			var key []byte
+			key, _, hasAttr = z.TagAttr()
+			if string(key) != IgnoreAttribute {
+				continue
+			}
+			if tokenType == html.SelfClosingTagToken || voidElements[string(tagName)] {
+				ranges = append(ranges, [2]int{tokenStart, offset})
+				break
+			}
+			elementTag = string(tagName)
+			elementStart = tokenStart
+			depth = 1
+			break
+		}
+	}
+}
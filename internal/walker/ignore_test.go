@@ -0,0 +1,100 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+)
+
+func TestExtractIgnoredCoversDirectives(t *testing.T) {
+	body := `<a href="/kept-1">
+<!-- linkpatrol-ignore-next-line -->
+<a href="/line">
+<a href="/kept-2">
+<div data-linkpatrol-ignore><div><a href="/nested"></a></div><a href="/element"></a></div>
+<img data-linkpatrol-ignore src="/void.png"><img src="/kept-3.png">
+[//]: # (linkpatrol-ignore-next-line)
+[markdown](/markdown)`
+
+	ignored := ExtractIgnored([]byte(body))
+	for _, link := range []string{"/line", "/nested", "/element", "/void.png", "/markdown"} {
+		if !ignored.Covers(strings.Index(body, link)) {
+			t.Errorf("%s isn't covered by a directive", link)
+		}
+	}
+	for _, link := range []string{"/kept-1", "/kept-2", "/kept-3.png"} {
+		if ignored.Covers(strings.Index(body, link)) {
+			t.Errorf("%s is covered by a directive", link)
+		}
+	}
+}
+
+func TestExtractIgnoredUnclosedElementRunsToTheEnd(t *testing.T) {
+	body := `<a href="/kept"><section data-linkpatrol-ignore><a href="/last">`
+	ignored := ExtractIgnored([]byte(body))
+	if ignored.Covers(strings.Index(body, "/kept")) || !ignored.Covers(strings.Index(body, "/last")) {
+		t.Errorf("ranges = %v", ignored)
+	}
+}
+
+func TestWalkSkipsOnlyTheIgnoredOccurrence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!-- linkpatrol-ignore-next-line -->
+<a href="/both">ignored here</a> <a href="/only-ignored">ignored</a>
+<a href="/both">but checked here</a>`)
+	}))
+	defer server.Close()
+
+	w, q, results := newTestWalker(t, server.URL, server.Client())
+	w.cache.DoLoop()
+	w.Walk(context.Background(), WalkerRequest{Path: server.URL + "/", Seed: true})
+
+	if !slices.Contains(q.links, server.URL+"/both") {
+		t.Errorf("the link outside the directive wasn't followed: %v", q.links)
+	}
+	if slices.Contains(q.links, server.URL+"/only-ignored") {
+		t.Errorf("the ignored link was followed: %v", q.links)
+	}
+
+	// Ignored links are only listed once the run is over, if nothing else checked them
+	close(results)
+	w.cache.Wait()
+	if result := w.cache.GetResult(server.URL + "/only-ignored"); result.Status != cache.Ignore {
+		t.Errorf("/only-ignored = %+v, want an Ignore result", result)
+	}
+	if w.cache.HasResult(server.URL + "/both") {
+		t.Error("/both was listed as ignored")
+	}
+}
+
+func TestWalkListsIgnoredFragmentsUnescaped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<h2 id="café">Café</h2>
+<!-- linkpatrol-ignore-next-line -->
+<a href="#caf%C3%A9">ignored here</a>
+<a href="#café">but checked here</a>`)
+	}))
+	defer server.Close()
+
+	w, _, results := newTestWalker(t, server.URL, server.Client())
+	w.cache.DoLoop()
+	w.Walk(context.Background(), WalkerRequest{Path: server.URL + "/", Seed: true})
+	close(results)
+	w.cache.Wait()
+
+	// Both links are the same one, which was checked
+	if result := w.cache.GetResult(server.URL + "/#café"); result.Status != cache.Live {
+		t.Errorf("/#café = %+v, want Live", result)
+	}
+	if w.cache.HasResult(server.URL + "/#caf%C3%A9") {
+		t.Error("the escaped fragment was listed separately")
+	}
+}
@@ -3,4 +3,6 @@ package walker
 type WalkerRequest struct {
 	BasePath string
 	Path     string
+	Depth    int  // number of links followed from a seed URL
+	Seed     bool // set for URLs the crawl was started from
 }
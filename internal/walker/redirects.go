@@ -0,0 +1,28 @@
+package walker
+
+import (
+	"net/http"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+)
+
+// RedirectChain returns every response the client followed to get resp,
+// ending with resp itself, or nil if the request wasn't redirected
+func RedirectChain(resp *http.Response) []cache.Redirect {
+	if resp == nil || resp.Request == nil || resp.Request.Response == nil {
+		return nil
+	}
+	var chain []cache.Redirect
+	for r := resp; r != nil; {
+		chain = append(chain, cache.Redirect{URL: r.Request.URL.String(), StatusCode: r.StatusCode})
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+	// The responses were collected from the last one back
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
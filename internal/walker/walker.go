@@ -2,12 +2,13 @@ package walker
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/time/rate"
 
@@ -15,33 +16,67 @@ import (
 	"github.com/sirprodigle/linkpatrol/internal/logger"
 )
 
+// MaxPageBytes caps how much of a page is read, so one huge or endless
+// response can't exhaust memory. Links and anchors past it aren't seen.
+const MaxPageBytes = 10 << 20
+
 type DomainLimiterProvider interface {
 	GetDomainLimiter(domain string) *rate.Limiter
+	// RateLimitWaited reports that a request to domain was held back by its rate limit
+	RateLimitWaited(domain string, waited time.Duration)
+}
+
+// Soft404Checker spots pages that answer 2xx but are really "not found" pages
+type Soft404Checker interface {
+	Check(ctx context.Context, target *url.URL, contentType string, body []byte) (bool, string)
+}
+
+// WalkQueue accepts same-site pages found by a walker that need crawling
+type WalkQueue interface {
+	EnqueueWalk(req WalkerRequest)
+}
+
+// TestQueue accepts links found by a walker that need testing
+type TestQueue interface {
+	EnqueueTest(req WalkerRequest)
 }
 
 type Walker struct {
 	client        *http.Client
-	toWalkChan    chan WalkerRequest
-	toTestChan    chan WalkerRequest
+	walkQueue     WalkQueue
+	testQueue     TestQueue
 	resultsChan   chan<- cache.CacheEntry
 	activeWalkers *atomic.Int32
 	cache         *cache.ResultsCache
+	pages         *cache.PageCache
 	logger        *logger.Logger
 	targetBaseUrl string
 	workerPool    DomainLimiterProvider
+
+	// logoutPatterns mark links that would end a logged-in session
+	logoutPatterns []string
+	// inScope decides which pages are crawled; nil means pages on targetBaseUrl's host
+	inScope func(*url.URL) bool
+	// soft404 checks crawled pages for soft 404s, if set
+	soft404 Soft404Checker
 }
 
-func NewWalker(client *http.Client, resultsCache *cache.ResultsCache, toWalkChan chan WalkerRequest, toTestChan chan WalkerRequest, activeWalkers *atomic.Int32, logger *logger.Logger, targetBaseUrl string, workerPool DomainLimiterProvider, resultsChan chan<- cache.CacheEntry) *Walker {
+func NewWalker(client *http.Client, resultsCache *cache.ResultsCache, pages *cache.PageCache, walkQueue WalkQueue, testQueue TestQueue, activeWalkers *atomic.Int32, logger *logger.Logger, targetBaseUrl string, workerPool DomainLimiterProvider, resultsChan chan<- cache.CacheEntry, logoutPatterns []string, inScope func(*url.URL) bool, soft404 Soft404Checker) *Walker {
 	return &Walker{
 		client:        client,
-		toWalkChan:    toWalkChan,
-		toTestChan:    toTestChan,
+		walkQueue:     walkQueue,
+		testQueue:     testQueue,
 		cache:         resultsCache,
+		pages:         pages,
 		activeWalkers: activeWalkers,
 		logger:        logger,
 		targetBaseUrl: targetBaseUrl,
 		workerPool:    workerPool,
 		resultsChan:   resultsChan,
+
+		logoutPatterns: logoutPatterns,
+		inScope:        inScope,
+		soft404:        soft404,
 	}
 }
 
@@ -51,6 +86,10 @@ var bannedPaths = []string{
 	"/wp-login.php",
 }
 
+// DefaultLogoutPatterns are the path fragments that mark a logout link when a
+// login is configured without its own patterns
+var DefaultLogoutPatterns = []string{"logout", "log-out", "log_out", "signout", "sign-out", "sign_out"}
+
 func (w *Walker) Walk(ctx context.Context, toTest WalkerRequest) {
 
 	w.activeWalkers.Add(1)
@@ -74,84 +113,180 @@ func (w *Walker) Walk(ctx context.Context, toTest WalkerRequest) {
 }
 
 func (w *Walker) walkUrl(ctx context.Context, toTest WalkerRequest) {
+	// Seeds weren't linked from anywhere
+	referrer := toTest.BasePath
+	if toTest.Seed {
+		referrer = ""
+	}
+
+	// Share what we learn about this page with testers checking fragment links to it
+	page := &cache.Page{}
+	finishPage := w.pages.Begin(toTest.Path)
+	defer func() { finishPage(page) }()
 
 	// Get domain-specific rate limiter
-	// Convert a null basepath to our target base url
-	domain := toTest.BasePath
-	if domain == "" {
-		domain = w.targetBaseUrl
+	domain := w.targetBaseUrl
+	if u, err := url.Parse(toTest.Path); err == nil && u.Host != "" {
+		domain = u.Host
 	}
 	domainLimiter := w.workerPool.GetDomainLimiter(domain)
 
 	// Wait for rate limiter permit
 	if !domainLimiter.Allow() {
-		w.logger.Progress("Waiting for rate limit permit for domain: %s", toTest.BasePath)
+		w.logger.Progress("Waiting for rate limit permit for domain: %s", domain)
+		waitStart := time.Now()
 		if err := domainLimiter.Wait(ctx); err != nil {
-			w.logger.Error("Error waiting for rate limit permit for domain: %s", toTest.BasePath)
+			w.logger.Error("Error waiting for rate limit permit for domain: %s", domain)
+			page.Error = err.Error()
 			return
 		}
+		w.workerPool.RateLimitWaited(domain, time.Since(waitStart))
 	}
 
 	// Make a HTTP request to the url
 	w.logger.Debug("Making HTTP request to url %s", toTest.Path)
-	resp, err := w.client.Get(toTest.Path)
+	req, err := http.NewRequestWithContext(ctx, "GET", toTest.Path, nil)
 	if err != nil {
+		w.logger.Error("Error creating HTTP request to url %s: %s", toTest.Path, err)
+		page.Error = err.Error()
+		w.resultsChan <- cache.CacheEntry{
+			URL:      toTest.Path,
+			Status:   cache.Dead,
+			Error:    err.Error(),
+			Referrer: referrer,
+		}
+		return
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		page.Error = err.Error()
+		// The run was interrupted, so this page was never really checked
+		if ctx.Err() != nil {
+			w.logger.Debug("Abandoned request to url %s: %s", toTest.Path, err)
+			return
+		}
 		w.logger.Error("Error making HTTP request to url %s: %s", toTest.Path, err)
 		w.resultsChan <- cache.CacheEntry{
-			URL:    toTest.Path,
-			Status: cache.Dead,
-			Error:  err.Error(),
+			URL:      toTest.Path,
+			Status:   cache.Dead,
+			Error:    err.Error(),
+			Referrer: referrer,
 		}
 		return
 	}
 	defer resp.Body.Close()
+	redirects := RedirectChain(resp)
+	page.StatusCode = resp.StatusCode
+	page.ContentType = resp.Header.Get("Content-Type")
+
+	// An error page's links aren't the site's, so don't crawl them
+	if resp.StatusCode >= 400 {
+		status := cache.Dead
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 999 {
+			status = cache.Bot
+		}
+		w.logger.Debug("❌ %s -> HTTP %d", toTest.Path, resp.StatusCode)
+		w.resultsChan <- cache.CacheEntry{
+			URL:       toTest.Path,
+			Status:    status,
+			Error:     (&url.Error{Op: "GET", URL: toTest.Path, Err: fmt.Errorf("HTTP %d", resp.StatusCode)}).Error(),
+			Referrer:  referrer,
+			Redirects: redirects,
+		}
+		return
+	}
 
-	w.logger.Progress("Reading entire body from url %s", toTest.Path)
+	w.logger.Progress("Reading body from url %s", toTest.Path)
 
-	// Read entire response body into memory
-	body, err := io.ReadAll(resp.Body)
+	// Read one byte past the limit so we can tell the page was cut short
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageBytes+1))
+	if len(body) > MaxPageBytes {
+		body = body[:MaxPageBytes]
+		page.Truncated = true
+	}
 	if err != nil {
 		w.logger.Error("Error reading body from url %s: %s", toTest.Path, err)
+		page.Error = err.Error()
 		w.resultsChan <- cache.CacheEntry{
-			URL:    toTest.Path,
-			Status: cache.Dead,
-			Error:  err.Error(),
+			URL:       toTest.Path,
+			Status:    cache.Dead,
+			Error:     err.Error(),
+			Referrer:  referrer,
+			Redirects: redirects,
 		}
 		return
 	}
 
+	// A not-found page served with 2xx is as broken as a 404, and its links are the template's
+	if w.soft404 != nil {
+		if u, err := url.Parse(toTest.Path); err == nil {
+			if isSoft404, reason := w.soft404.Check(ctx, u, page.ContentType, body); isSoft404 {
+				w.logger.Debug("👻 %s -> SOFT 404 (%s)", toTest.Path, reason)
+				w.resultsChan <- cache.CacheEntry{
+					URL:       toTest.Path,
+					Status:    cache.Soft404,
+					Error:     reason,
+					Referrer:  referrer,
+					Redirects: redirects,
+				}
+				return
+			}
+		}
+	}
+
 	// Mark as live since we successfully read the body
 	w.logger.Debug("Sending result to resultsChan for url %s", toTest.Path)
 	w.resultsChan <- cache.CacheEntry{
-		URL:    toTest.Path,
-		Status: cache.Live,
-		Error:  "",
+		URL:       toTest.Path,
+		Status:    cache.Live,
+		Error:     "",
+		Referrer:  referrer,
+		Redirects: redirects,
 	}
 
+	page.Anchors = ExtractAnchors(body)
+	ignored := ExtractIgnored(body)
+
 	// Process entire body with all regexes
 	bodyText := string(body)
 	regexes := GetRegexes()
 	seenUrls := make(map[string]bool)
 
+	// Links a directive covers, kept until we know the page doesn't also link them elsewhere
+	skipped := make(map[string]bool)
+
 	for regexId, regex := range regexes {
-		matches := regex.FindAllStringSubmatch(bodyText, -1)
+		matches := regex.FindAllStringSubmatchIndex(bodyText, -1)
 		for _, match := range matches {
 			if len(match) == 0 {
 				continue
 			}
 
-			var matchedUrl string
-			if len(match) > 1 {
+			var start, end int
+			if len(match) > 2 && match[2] >= 0 {
 				// Use capture group (match[1]) for HTML patterns that extract URLs from attributes
-				matchedUrl = match[1]
+				start, end = match[2], match[3]
 			} else {
 				// Use full match (match[0]) for patterns that match the URL directly
-				matchedUrl = match[0]
+				start, end = match[0], match[1]
+			}
+			matchedUrl := bodyText[start:end]
+
+			// The page's author asked for this occurrence of the link not to be checked
+			if ignored.Covers(start) {
+				if regexId == ImgSrcsetRegexIdentifier {
+					for _, srcsetUrl := range srcsetUrls(matchedUrl) {
+						skipped[srcsetUrl] = true
+					}
+				} else {
+					skipped[matchedUrl] = true
+				}
+				continue
 			}
 
 			// Special handling for srcset - extract individual URLs
 			if regexId == ImgSrcsetRegexIdentifier {
-				w.processSrcsetUrls(matchedUrl, toTest, seenUrls, bodyText)
+				w.processSrcsetUrls(matchedUrl, toTest, seenUrls, page.Anchors)
 				continue
 			}
 
@@ -161,73 +296,175 @@ func (w *Walker) walkUrl(ctx context.Context, toTest WalkerRequest) {
 			}
 			seenUrls[matchedUrl] = true
 
-			w.logger.Trace("Found match: %s on url %s", match[0], toTest.Path)
-			w.processFoundUrl(matchedUrl, toTest, bodyText)
+			w.logger.Trace("Found match: %s on url %s", bodyText[match[0]:match[1]], toTest.Path)
+			w.processFoundUrl(matchedUrl, toTest, page.Anchors)
+		}
+	}
+
+	for matchedUrl := range skipped {
+		if !seenUrls[matchedUrl] {
+			w.ignoreLink(matchedUrl, toTest)
 		}
 	}
 }
 
 // processSrcsetUrls extracts individual URLs from srcset attribute values
-func (w *Walker) processSrcsetUrls(srcsetValue string, toTest WalkerRequest, seenUrls map[string]bool, bodyText string) {
-	// srcset format: "url1 descriptor1, url2 descriptor2, ..."
-	// Extract URLs (everything before whitespace or comma)
-	urls := strings.Split(srcsetValue, ",")
-	for _, urlEntry := range urls {
-		urlEntry = strings.TrimSpace(urlEntry)
-		if urlEntry == "" {
-			continue
+func (w *Walker) processSrcsetUrls(srcsetValue string, toTest WalkerRequest, seenUrls map[string]bool, anchors map[string]bool) {
+	for _, url := range srcsetUrls(srcsetValue) {
+		if !seenUrls[url] {
+			seenUrls[url] = true
+			w.logger.Trace("Found srcset URL: %s on url %s", url, toTest.Path)
+			w.processFoundUrl(url, toTest, anchors)
 		}
+	}
+}
 
+// srcsetUrls returns the URLs of a srcset attribute value
+func srcsetUrls(srcsetValue string) []string {
+	// srcset format: "url1 descriptor1, url2 descriptor2, ..."
+	// Extract URLs (everything before whitespace or comma)
+	var urls []string
+	for _, urlEntry := range strings.Split(srcsetValue, ",") {
 		// Split by whitespace to get just the URL part (before descriptor like "330w" or "2x")
-		parts := strings.Fields(urlEntry)
-		if len(parts) > 0 {
-			url := strings.TrimSpace(parts[0])
-			if url != "" && !seenUrls[url] {
-				seenUrls[url] = true
-				w.logger.Trace("Found srcset URL: %s on url %s", url, toTest.Path)
-				w.processFoundUrl(url, toTest, bodyText)
-			}
+		if parts := strings.Fields(urlEntry); len(parts) > 0 {
+			urls = append(urls, parts[0])
 		}
 	}
+	return urls
 }
 
-// processFoundUrl handles a discovered URL
-func (w *Walker) processFoundUrl(matchedUrl string, toTest WalkerRequest, bodyText string) {
+// processFoundUrl handles a discovered URL. anchors are the fragment targets
+// on the page being walked, used to check links to fragments of the same page.
+func (w *Walker) processFoundUrl(matchedUrl string, toTest WalkerRequest, anchors map[string]bool) {
+	// Following a logout link would end the session the rest of the crawl relies on
+	if w.isLogoutLink(matchedUrl) {
+		w.logger.Debug("Skipping url: %s, it looks like a logout link", matchedUrl)
+		return
+	}
+
+	// Fragments of this page can be checked right away
+	if strings.HasPrefix(matchedUrl, "#") {
+		w.checkLocalFragment(toTest.Path, strings.TrimPrefix(matchedUrl, "#"), anchors)
+		return
+	}
+
 	if w.IsSameDomain(matchedUrl, w.targetBaseUrl) {
 		w.logger.Debug("Sending same domain url to walker: %s", matchedUrl)
-		// Resolve relative URLs using BasePath
+		// Resolve relative URLs against the page they were found on
 		resolvedURL := matchedUrl
-		if parsed, err := url.Parse(matchedUrl); err == nil && parsed.Host == "" && toTest.BasePath != "" {
-			base, err := url.Parse(toTest.BasePath)
-			if err == nil {
+		if parsed, err := url.Parse(matchedUrl); err == nil {
+			if base, err := url.Parse(toTest.Path); err == nil {
 				resolvedURL = base.ResolveReference(parsed).String()
 				w.logger.Debug("🟦 Resolved URL: %s", resolvedURL)
 			}
 		}
-		w.toWalkChan <- WalkerRequest{
-			Path:     resolvedURL,
-			BasePath: toTest.BasePath,
+
+		// Walk the page itself and check the fragment separately
+		pageURL, fragment, hasFragment := strings.Cut(resolvedURL, "#")
+		if hasFragment && pageURL == toTest.Path {
+			w.checkLocalFragment(toTest.Path, fragment, anchors)
+			return
+		}
+		w.walkQueue.EnqueueWalk(WalkerRequest{
+			Path:     pageURL,
+			BasePath: toTest.Path,
+			Depth:    toTest.Depth + 1,
+		})
+		if hasFragment {
+			w.testQueue.EnqueueTest(WalkerRequest{
+				Path:     resolvedURL,
+				BasePath: toTest.Path,
+				Depth:    toTest.Depth + 1,
+			})
 		}
 	} else {
 		w.logger.Debug("Sending url to tester: %s", matchedUrl)
-		if strings.HasPrefix(matchedUrl, "#") {
-			// Check directly for an id tag in the body
-			if matched, _ := regexp.Match("id=\""+matchedUrl+"\"", []byte(bodyText)); matched {
-				matchedUrl = toTest.Path + matchedUrl
-				// Store directly in resultsChan
-				w.resultsChan <- cache.CacheEntry{
-					URL:    matchedUrl,
-					Status: cache.Live,
-					Error:  "",
-				}
-				return
-			}
-		}
-		w.toTestChan <- WalkerRequest{
+		w.testQueue.EnqueueTest(WalkerRequest{
 			Path:     matchedUrl,
-			BasePath: toTest.BasePath,
+			BasePath: toTest.Path,
+			Depth:    toTest.Depth + 1,
+		})
+	}
+}
+
+// checkLocalFragment records whether a fragment link resolves on the page it was found on
+func (w *Walker) checkLocalFragment(pageURL, fragment string, anchors map[string]bool) {
+	fragment = UnescapeFragment(fragment)
+	key := pageURL + "#" + fragment
+	if !w.cache.TryClaim(key) {
+		return
+	}
+
+	if HasAnchor(anchors, fragment) {
+		w.logger.Debug("✅ %s -> LIVE (anchor found)", key)
+		w.resultsChan <- cache.CacheEntry{
+			URL:      key,
+			Status:   cache.Live,
+			Error:    "",
+			Referrer: pageURL,
+		}
+		return
+	}
+
+	w.logger.Debug("⚓ %s -> MISSING ANCHOR", key)
+	w.resultsChan <- cache.CacheEntry{
+		URL:      key,
+		Status:   cache.MissingAnchor,
+		Error:    fmt.Sprintf("No element with id or name '%s' on page %s", fragment, pageURL),
+		Referrer: pageURL,
+	}
+}
+
+// ignoreLink lists a link an inline directive excludes without checking it.
+// The link isn't claimed, so other pages linking to it still get it checked,
+// and it's only listed as ignored if none does.
+func (w *Walker) ignoreLink(matchedUrl string, toTest WalkerRequest) {
+	resolvedURL := matchedUrl
+	if parsed, err := url.Parse(matchedUrl); err == nil {
+		if base, err := url.Parse(toTest.Path); err == nil {
+			resolvedURL = base.ResolveReference(parsed).String()
 		}
 	}
+	// Fragment links are recorded with the fragment unescaped, so list it the same way
+	if pageURL, fragment, found := strings.Cut(resolvedURL, "#"); found {
+		resolvedURL = pageURL + "#" + UnescapeFragment(fragment)
+	}
+
+	w.logger.Debug("🔕 %s -> IGNORED (inline directive)", resolvedURL)
+	w.cache.Skip(cache.CacheEntry{
+		URL:      resolvedURL,
+		Status:   cache.Ignore,
+		Error:    "inline directive",
+		Referrer: toTest.Path,
+	})
+}
+
+// UnescapeFragment decodes the percent escapes in a link's fragment, which is
+// how fragment links are recorded and compared with a page's anchors. A
+// fragment that doesn't decode is kept as written.
+func UnescapeFragment(fragment string) string {
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		return unescaped
+	}
+	return fragment
+}
+
+// isLogoutLink reports whether link's path contains one of the logout patterns
+func (w *Walker) isLogoutLink(link string) bool {
+	if len(w.logoutPatterns) == 0 {
+		return false
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	path := strings.ToLower(u.Path)
+	for _, pattern := range w.logoutPatterns {
+		if pattern != "" && strings.Contains(path, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
 }
 
 func (w *Walker) IsSameDomain(target string, baseUrl string) bool {
@@ -273,6 +510,10 @@ func (w *Walker) IsSameDomain(target string, baseUrl string) bool {
 		return true
 	}
 
+	if w.inScope != nil {
+		return w.inScope(parsedTarget)
+	}
+
 	parsedBaseUrl, err := url.Parse(baseUrl)
 	if err != nil {
 		w.logger.Error("Error parsing base url: %s", err)
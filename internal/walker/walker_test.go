@@ -0,0 +1,84 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+)
+
+type unlimited struct{}
+
+func (unlimited) GetDomainLimiter(string) *rate.Limiter { return rate.NewLimiter(rate.Inf, 0) }
+func (unlimited) RateLimitWaited(string, time.Duration) {}
+
+// queued records the links a walker hands on, instead of crawling or testing them
+type queued struct {
+	links []string
+}
+
+func (q *queued) EnqueueWalk(req WalkerRequest) { q.links = append(q.links, req.Path) }
+func (q *queued) EnqueueTest(req WalkerRequest) { q.links = append(q.links, req.Path) }
+
+func newTestWalker(t *testing.T, baseURL string, client *http.Client) (*Walker, *queued, chan cache.CacheEntry) {
+	t.Helper()
+	results := make(chan cache.CacheEntry, 10)
+	q := &queued{}
+	log := logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard))
+	w := NewWalker(client, cache.NewResultsCache(results), cache.NewPageCache(), q, q, &atomic.Int32{}, log, baseURL, unlimited{}, results, nil, nil, nil)
+	return w, q, results
+}
+
+func TestWalkClassifiesErrorPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codes := map[string]int{
+			"/ok":        http.StatusOK,
+			"/gone":      http.StatusNotFound,
+			"/broken":    http.StatusInternalServerError,
+			"/forbidden": http.StatusForbidden,
+			"/throttled": http.StatusTooManyRequests,
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(codes[r.URL.Path])
+		fmt.Fprint(w, `<a href="/from-page">a link</a>`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status cache.CacheEntryStatus
+	}{
+		{"/ok", cache.Live},
+		{"/gone", cache.Dead},
+		{"/broken", cache.Dead},
+		{"/forbidden", cache.Bot},
+		{"/throttled", cache.Bot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w, q, results := newTestWalker(t, server.URL, server.Client())
+			w.Walk(context.Background(), WalkerRequest{Path: server.URL + tt.path, BasePath: server.URL + "/"})
+
+			result := <-results
+			if result.Status != tt.status {
+				t.Errorf("status = %s (%s), want %s", result.Status, result.Error, tt.status)
+			}
+			if result.Referrer != server.URL+"/" {
+				t.Errorf("referrer = %q", result.Referrer)
+			}
+			// An error page's links are the server's, not the site's
+			if crawled := len(q.links) > 0; crawled != (tt.status == cache.Live) {
+				t.Errorf("links followed = %v", q.links)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Package webhook POSTs the results of a run to webhooks, with payloads
+// rendered from user-supplied text/template templates so one feature can talk
+// to chat tools and incident trackers alike
+package webhook
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/envvar"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the payload, as "sha256=<hex>",
+// when the webhook has a secret
+const SignatureHeader = "X-LinkPatrol-Signature"
+
+// defaultTemplate sends the whole summary as JSON
+const defaultTemplate = "{{json .}}"
+
+const defaultRetries = 3
+
+// retryBackoff is the wait before the first retry, doubled for each one after
+var retryBackoff = time.Second
+
+// Failure is a broken or timed out link, as passed to templates
+type Failure struct {
+	URL      string `json:"url"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+}
+
+// Alert is a link whose state changed between monitor runs, as passed to templates
+type Alert struct {
+	URL      string `json:"url"`
+	Referrer string `json:"referrer,omitempty"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"` // up, down or flapping
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Failures int    `json:"failures"` // failing runs in a row
+}
+
+// Summary is the data webhook templates are executed with
+type Summary struct {
+	Target string `json:"target"`
+	// Partial is set when the run was interrupted, so some links weren't checked
+	Partial  bool           `json:"partial"`
+	Passed   bool           `json:"passed"`
+	Total    int            `json:"total"`
+	Broken   int            `json:"broken"`
+	TimedOut int            `json:"timed_out"`
+	Counts   map[string]int `json:"counts"` // number of results by status
+	Failures []Failure      `json:"failures"`
+	// Alerts are the state changes of a monitor run, and empty for other runs
+	Alerts []Alert `json:"alerts,omitempty"`
+}
+
+// NewSummary summarises report for webhook templates. Whether the run passed
+// is up to the caller, since a baseline can forgive its failures.
+func NewSummary(target string, report *linkpatrol.Report, passed bool) Summary {
+	summary := Summary{
+		Target:   target,
+		Partial:  report.Partial,
+		Passed:   passed,
+		Total:    len(report.Results),
+		Counts:   make(map[string]int),
+		Failures: []Failure{},
+	}
+	summary.Broken, summary.TimedOut = report.FailureCount()
+	for _, result := range report.Results {
+		summary.Counts[result.Status.String()]++
+	}
+	for _, result := range report.Failures() {
+		summary.Failures = append(summary.Failures, Failure{
+			URL:      result.URL,
+			Status:   result.Status.String(),
+			Error:    result.Error,
+			Referrer: result.Referrer,
+		})
+	}
+	sort.Slice(summary.Failures, func(i, j int) bool {
+		return summary.Failures[i].URL < summary.Failures[j].URL
+	})
+	return summary
+}
+
+// Hook is a configured webhook, ready to send
+type Hook struct {
+	Name string
+	URL  string
+	// origin is the URL's scheme and host, all of it that's safe to log: the
+	// rest can hold a secret expanded from the environment
+	origin      string
+	contentType string
+	headers     map[string]string
+	secret      []byte
+	retries     int
+	onlyFailure bool
+	template    *template.Template
+	client      *http.Client
+}
+
+var funcs = template.FuncMap{
+	// json encodes a value, so strings can be embedded safely in JSON payloads
+	"json": func(v any) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+	"join": strings.Join,
+}
+
+// New parses the configured webhooks. Secrets and ${NAME} variables in URLs
+// and header values are read from the environment and templates loaded now,
+// so a bad configuration fails before the crawl.
+func New(webhooks []config.Webhook) ([]*Hook, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	hooks := make([]*Hook, 0, len(webhooks))
+	for i, webhook := range webhooks {
+		name := cmp.Or(webhook.Name, fmt.Sprintf("webhook %d", i+1))
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("%s: url is required", name)
+		}
+		hookURL, err := envvar.Expand(webhook.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: url: %w", name, err)
+		}
+		hook := &Hook{
+			Name:        name,
+			URL:         hookURL,
+			contentType: cmp.Or(webhook.ContentType, "application/json"),
+			headers:     make(map[string]string, len(webhook.Headers)),
+			retries:     defaultRetries,
+			client:      client,
+		}
+		for header, value := range webhook.Headers {
+			if hook.headers[header], err = envvar.Expand(value); err != nil {
+				return nil, fmt.Errorf("%s: header %s: %w", name, header, err)
+			}
+		}
+		// url.Parse's error quotes the URL, so it's left out
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s: url must be an absolute http or https URL", name)
+		}
+		hook.origin = u.Scheme + "://" + u.Host
+		if webhook.Retries != nil {
+			hook.retries = max(0, *webhook.Retries)
+		}
+		switch webhook.SendOn {
+		case "", "always":
+		case "failure":
+			hook.onlyFailure = true
+		default:
+			return nil, fmt.Errorf("%s: send-on must be \"always\" or \"failure\", not %q", name, webhook.SendOn)
+		}
+		if webhook.SecretEnv != "" {
+			secret := os.Getenv(webhook.SecretEnv)
+			if secret == "" {
+				return nil, fmt.Errorf("%s: environment variable %s for the signing secret is not set", name, webhook.SecretEnv)
+			}
+			hook.secret = []byte(secret)
+		}
+
+		text := cmp.Or(webhook.Template, defaultTemplate)
+		if webhook.TemplateFile != "" {
+			contents, err := os.ReadFile(webhook.TemplateFile)
+			if err != nil {
+				return nil, fmt.Errorf("%s: reading template: %w", name, err)
+			}
+			text = string(contents)
+		}
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: parsing template: %w", name, err)
+		}
+		hook.template = tmpl
+
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// Wants reports whether the hook should be sent for a run with this summary
+func (h *Hook) Wants(summary Summary) bool {
+	return !h.onlyFailure || !summary.Passed
+}
+
+// Render executes the hook's template with summary
+func (h *Hook) Render(summary Summary) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := h.template.Execute(&payload, summary); err != nil {
+		return nil, fmt.Errorf("%s: rendering template: %w", h.Name, err)
+	}
+	return payload.Bytes(), nil
+}
+
+// Send POSTs payload to the hook, retrying network errors, 429s and 5xx
+// responses with a growing backoff
+func (h *Hook) Send(ctx context.Context, payload []byte) error {
+	var err error
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff << (attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var retry bool
+		if retry, err = h.post(ctx, payload); !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("%s: giving up after %d attempts: %w", h.Name, h.retries+1, err)
+}
+
+// post sends payload once and reports whether a failure is worth retrying
+func (h *Hook) post(ctx context.Context, payload []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, fmt.Errorf("%s: creating the request for %s failed", h.Name, h.origin)
+	}
+	req.Header.Set("Content-Type", h.contentType)
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+	if h.secret != nil {
+		mac := hmac.New(sha256.New, h.secret)
+		mac.Write(payload)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		// A *url.Error quotes the whole URL, so only what went wrong is kept
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return ctx.Err() == nil, fmt.Errorf("%s: POST %s: %w", h.Name, h.origin, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("%s: HTTP %d", h.Name, resp.StatusCode)
+	}
+	return false, nil
+}
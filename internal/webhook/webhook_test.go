@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/config"
+)
+
+func TestSendKeepsTheURLOutOfErrors(t *testing.T) {
+	t.Setenv("HOOK_PATH", "T000/B000/secret-token")
+	server := httptest.NewServer(nil)
+	server.Close() // refuse the connection
+
+	retries := 0
+	hooks, err := New([]config.Webhook{{Name: "slack", URL: server.URL + "/services/${HOOK_PATH}", Retries: &retries}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = hooks[0].Send(context.Background(), []byte("{}"))
+	if err == nil {
+		t.Fatal("sending to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error leaks the URL: %s", err)
+	}
+	if !strings.Contains(err.Error(), server.URL) {
+		t.Errorf("error doesn't say which host failed: %s", err)
+	}
+}
+
+func TestNewRejectsInvalidURLsWithoutQuotingThem(t *testing.T) {
+	t.Setenv("HOOK_SECRET", "secret-token")
+	for _, raw := range []string{"hooks.example.com/${HOOK_SECRET}", "ftp://hooks.example.com/${HOOK_SECRET}", "https://%zz/${HOOK_SECRET}"} {
+		_, err := New([]config.Webhook{{URL: raw}})
+		if err == nil {
+			t.Errorf("accepted %s", raw)
+			continue
+		}
+		if strings.Contains(err.Error(), "secret-token") {
+			t.Errorf("error leaks the URL: %s", err)
+		}
+	}
+}
+
+func TestNewFailsOnUnsetVariables(t *testing.T) {
+	t.Setenv("HOOK_PATH", "T000/B000/secret-token")
+	for name, webhook := range map[string]config.Webhook{
+		"url":    {URL: "https://hooks.example.com/${HOOK_UNSET}"},
+		"header": {URL: "https://hooks.example.com/${HOOK_PATH}", Headers: map[string]string{"Authorization": "Bearer ${HOOK_UNSET}"}},
+	} {
+		_, err := New([]config.Webhook{webhook})
+		if err == nil || !strings.Contains(err.Error(), "HOOK_UNSET is not set") {
+			t.Errorf("%s: err = %v, want one naming HOOK_UNSET", name, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	summary := Summary{
+		Target:   "https://example.com",
+		Broken:   1,
+		Failures: []Failure{{URL: `https://example.com/"quoted"`, Status: "Dead"}},
+	}
+	tests := []struct {
+		name, template, want string
+	}{
+		{"json", `{"text": {{json (printf "%d broken on %s" .Broken .Target)}}}`, `{"text": "1 broken on https://example.com"}`},
+		{"failures", `{{range .Failures}}{{json .URL}} {{.Status}}{{end}}`, `"https://example.com/\"quoted\"" Dead`},
+		{"conditional", `{{if .Passed}}ok{{else}}{{.Broken}} broken{{end}}`, `1 broken`},
+	}
+	for _, tt := range tests {
+		hooks, err := New([]config.Webhook{{URL: "https://hooks.example.com", Template: tt.template}})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		payload, err := hooks[0].Render(summary)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(payload) != tt.want {
+			t.Errorf("%s: rendered %s, want %s", tt.name, payload, tt.want)
+		}
+	}
+}
+
+func TestRenderFailsOnMissingKeys(t *testing.T) {
+	hooks, err := New([]config.Webhook{{URL: "https://hooks.example.com", Template: `{{.Counts.Dead}} {{.Counts.Gone}}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := hooks[0].Render(Summary{Counts: map[string]int{"Dead": 1}}); err == nil {
+		t.Errorf("rendered %s with a missing key", payload)
+	}
+}
+
+func TestSendSignsThePayload(t *testing.T) {
+	t.Setenv("HOOK_SECRET", "s3cret")
+	var signature, team string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, team = r.Header.Get(SignatureHeader), r.Header.Get("X-Team")
+	}))
+	defer server.Close()
+
+	hooks, err := New([]config.Webhook{{URL: server.URL, SecretEnv: "HOOK_SECRET", Headers: map[string]string{"X-Team": "web"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hooks[0].Send(context.Background(), []byte(`{"passed":false}`)); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(`{"passed":false}`))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+	if team != "web" {
+		t.Errorf("X-Team = %q, want web", team)
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	tests := []struct {
+		name     string
+		statuses []int // answered in turn, then 200
+		err      bool
+		attempts int
+	}{
+		{"429 and 5xx are retried", []int{http.StatusTooManyRequests, http.StatusBadGateway}, false, 3},
+		{"4xx isn't retried", []int{http.StatusNotFound}, true, 1},
+		{"retries run out", []int{500, 500, 500, 500}, true, 3},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts < len(tt.statuses) {
+				w.WriteHeader(tt.statuses[attempts])
+			}
+			attempts++
+		}))
+		retries := 2
+		hooks, err := New([]config.Webhook{{URL: server.URL, Retries: &retries}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = hooks[0].Send(context.Background(), []byte("{}"))
+		server.Close()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("%s: sent %d times, want %d", tt.name, attempts, tt.attempts)
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	hooks, err := New([]config.Webhook{
+		{URL: "https://hooks.example.com/always"},
+		{URL: "https://hooks.example.com/failure", SendOn: "failure"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	always, onFailure := hooks[0], hooks[1]
+	if !always.Wants(Summary{Passed: true}) || !always.Wants(Summary{Passed: false}) {
+		t.Error("a default hook skipped a run")
+	}
+	if onFailure.Wants(Summary{Passed: true}) {
+		t.Error("a send-on: failure hook wants a passing run")
+	}
+	if !onFailure.Wants(Summary{Passed: false}) {
+		t.Error("a send-on: failure hook skipped a failing run")
+	}
+	if _, err := New([]config.Webhook{{URL: "https://hooks.example.com", SendOn: "sometimes"}}); err == nil {
+		t.Error("accepted send-on: sometimes")
+	}
+}
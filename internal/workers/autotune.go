@@ -0,0 +1,98 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const autoTuneInterval = time.Second
+
+// testerTuner adjusts the number of running testers between a floor and a
+// ceiling. Testers are added while the test backlog outgrows them and latency
+// stays close to the best seen, and retired again once the backlog is gone.
+type testerTuner struct {
+	min int
+	max int
+
+	mu       sync.Mutex
+	latency  time.Duration // moving average of test latency
+	baseline time.Duration // lowest moving average seen so far
+}
+
+func newTesterTuner(min, max int) *testerTuner {
+	if max < min {
+		max = min
+	}
+	return &testerTuner{min: min, max: max}
+}
+
+// Observe records how long a single test took
+func (t *testerTuner) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.latency == 0 {
+		t.latency = d
+	} else {
+		t.latency = (t.latency*7 + d) / 8
+	}
+	if t.baseline == 0 || t.latency < t.baseline {
+		t.baseline = t.latency
+	}
+}
+
+// target returns the number of testers that should be running given the
+// current target, how many testers are busy and the size of the backlog
+func (t *testerTuner) target(running, busy, backlog int) int {
+	t.mu.Lock()
+	latency, baseline := t.latency, t.baseline
+	t.mu.Unlock()
+
+	// Latency climbing well past the best we've seen means the network or the
+	// remote hosts are saturated, so more testers won't help
+	congested := baseline > 0 && latency > 2*baseline
+
+	switch {
+	case backlog > running && busy >= running && !congested && running < t.max:
+		return min(t.max, running+running/2+1)
+	case backlog == 0 && busy < running && running > t.min:
+		return max(t.min, running-max(1, running/4))
+	case congested && running > t.min:
+		return max(t.min, running-1)
+	}
+	return running
+}
+
+// autoTuneTesters periodically grows or shrinks the tester pool until ctx is done
+func (wp *WorkerPool) autoTuneTesters(ctx context.Context) {
+	ticker := time.NewTicker(autoTuneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := int(wp.testerTarget.Load())
+		target := wp.tuner.target(current, int(wp.activeTesters.Load()), wp.testQueue.Len())
+		if target == current {
+			continue
+		}
+
+		wp.logger.Debug("Auto-tune: adjusting testers from %d to %d", current, target)
+		wp.testerTarget.Store(int32(target))
+		if target < current {
+			// Busy testers above the target retire after their current job,
+			// and idle ones as soon as they're woken
+			wp.wakeIdleTesters(ctx)
+			continue
+		}
+		// Only spawn enough to make up the difference with what is still running
+		for i := int(wp.testerCount.Load()); i < target; i++ {
+			wp.spawnTester(ctx)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package workers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+)
+
+func TestTesterTunerTarget(t *testing.T) {
+	tests := []struct {
+		name                   string
+		latency, baseline      time.Duration
+		running, busy, backlog int
+		want                   int
+	}{
+		{"grows by half while the backlog outgrows busy testers", 100, 100, 4, 4, 10, 7},
+		{"growth stops at the ceiling", 100, 100, 14, 14, 100, 16},
+		{"stays at the ceiling", 100, 100, 16, 16, 100, 16},
+		{"doesn't grow with idle testers", 100, 100, 4, 3, 10, 4},
+		{"doesn't grow a small backlog", 100, 100, 4, 4, 4, 4},
+		{"doesn't grow while congested", 300, 100, 4, 4, 10, 3},
+		{"shrinks by a quarter once the backlog is gone", 100, 100, 12, 2, 0, 9},
+		{"shrinks by at least one", 100, 100, 3, 0, 0, 2},
+		{"shrinking stops at the floor", 100, 100, 2, 0, 0, 2},
+		{"never goes below the floor", 100, 100, 3, 1, 0, 2},
+		{"sheds one tester while congested", 300, 100, 8, 8, 0, 7},
+		{"congestion doesn't go below the floor", 300, 100, 2, 2, 10, 2},
+		{"steady with busy testers and no backlog", 100, 100, 6, 6, 0, 6},
+		{"no latency seen yet", 0, 0, 4, 4, 10, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuner := newTesterTuner(2, 16)
+			tuner.latency, tuner.baseline = tt.latency, tt.baseline
+			if got := tuner.target(tt.running, tt.busy, tt.backlog); got != tt.want {
+				t.Errorf("target(%d running, %d busy, %d queued) = %d, want %d", tt.running, tt.busy, tt.backlog, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTesterTunerObserveTracksTheBestLatency(t *testing.T) {
+	tuner := newTesterTuner(1, 4)
+	tuner.Observe(800 * time.Millisecond)
+	tuner.Observe(0)
+	if tuner.latency != 700*time.Millisecond || tuner.baseline != 700*time.Millisecond {
+		t.Errorf("latency %s, baseline %s, want both 700ms", tuner.latency, tuner.baseline)
+	}
+	tuner.Observe(1500 * time.Millisecond)
+	if tuner.latency != 800*time.Millisecond || tuner.baseline != 700*time.Millisecond {
+		t.Errorf("latency %s, baseline %s, want 800ms and 700ms", tuner.latency, tuner.baseline)
+	}
+}
+
+func TestIdleTestersRetireWhenTheTargetDrops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := logger.New(false, logger.WithOutput(io.Discard), logger.WithErrorOutput(io.Discard))
+	results := make(chan cache.CacheEntry, 10)
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	wp := NewWorkerPool(cache.NewResultsCache(results), 1, 4, time.Second, 0, 0, results, log, server.URL+"/", server.Client())
+	wp.EnableAutoTune(8)
+	// Only the testers are started, without the tuner's own ticks
+	wp.requestCtx = ctx
+	wp.startTesters(ctx)
+
+	// Nothing is queued, so all four testers end up waiting for work
+	time.Sleep(50 * time.Millisecond)
+	wp.testerTarget.Store(2)
+	wp.wakeIdleTesters(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for wp.testerCount.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := wp.testerCount.Load(); count != 2 {
+		t.Fatalf("%d testers running, want 2", count)
+	}
+
+	// The testers left still pick up work
+	wp.EnqueueTest(link(server.URL + "/gone"))
+	select {
+	case <-wp.work.Wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the remaining testers didn't take the queued link")
+	}
+}
@@ -0,0 +1,122 @@
+package workers
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/sirprodigle/linkpatrol/internal/walker"
+)
+
+// frontier is the unbounded queue of pages waiting to be walked. Push never
+// blocks, so a walker can always hand off the pages it finds. Pages come out
+// breadth-first: seeds before everything else, then by depth, then pages on
+// the target site before other hosts, then in the order they were found.
+type frontier struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	items      frontierHeap
+	seq        uint64
+	closed     bool
+	isInternal func(req walker.WalkerRequest) bool
+}
+
+type frontierItem struct {
+	req      walker.WalkerRequest
+	internal bool
+	seq      uint64
+}
+
+func newFrontier(isInternal func(req walker.WalkerRequest) bool) *frontier {
+	f := &frontier{
+		items:      make(frontierHeap, 0, 1000),
+		isInternal: isInternal,
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Push adds a page to the frontier
+func (f *frontier) Push(req walker.WalkerRequest) {
+	internal := f.isInternal(req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	heap.Push(&f.items, frontierItem{req: req, internal: internal, seq: f.seq})
+	f.cond.Signal()
+}
+
+// Next blocks until a page is available and returns the highest priority one.
+// ok is false once the frontier is closed or ctx is cancelled.
+func (f *frontier) Next(ctx context.Context) (req walker.WalkerRequest, ok bool) {
+	stop := context.AfterFunc(ctx, func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+	defer stop()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if f.closed || ctx.Err() != nil {
+			return walker.WalkerRequest{}, false
+		}
+		if len(f.items) > 0 {
+			return heap.Pop(&f.items).(frontierItem).req, true
+		}
+		f.cond.Wait()
+	}
+}
+
+// Len returns the number of pages waiting to be walked
+func (f *frontier) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.items)
+}
+
+// Close wakes every waiting walker and makes further calls to Next return false
+func (f *frontier) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+}
+
+// frontierHeap implements heap.Interface ordered by crawl priority
+type frontierHeap []frontierItem
+
+func (h frontierHeap) Len() int { return len(h) }
+
+func (h frontierHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.req.Seed != b.req.Seed {
+		return a.req.Seed
+	}
+	if a.req.Depth != b.req.Depth {
+		return a.req.Depth < b.req.Depth
+	}
+	if a.internal != b.internal {
+		return a.internal
+	}
+	return a.seq < b.seq
+}
+
+func (h frontierHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *frontierHeap) Push(x any) {
+	*h = append(*h, x.(frontierItem))
+}
+
+func (h *frontierHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = frontierItem{}
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,88 @@
+package workers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/walker"
+)
+
+func TestFrontierOrder(t *testing.T) {
+	f := newFrontier(func(req walker.WalkerRequest) bool {
+		return strings.HasPrefix(req.Path, "https://site.example/")
+	})
+	// Pushed in the reverse of the order they should come out
+	f.Push(walker.WalkerRequest{Path: "https://other.example/deep", Depth: 2})
+	f.Push(walker.WalkerRequest{Path: "https://site.example/deep", Depth: 2})
+	f.Push(walker.WalkerRequest{Path: "https://other.example/a", Depth: 1})
+	f.Push(walker.WalkerRequest{Path: "https://site.example/b", Depth: 1})
+	f.Push(walker.WalkerRequest{Path: "https://site.example/a", Depth: 1})
+	f.Push(walker.WalkerRequest{Path: "https://site.example/seed", Depth: 3, Seed: true})
+
+	want := []string{
+		"https://site.example/seed", // seeds first, whatever their depth
+		"https://site.example/b",    // then by depth, the target site first, in the order found
+		"https://site.example/a",
+		"https://other.example/a",
+		"https://site.example/deep",
+		"https://other.example/deep",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, path := range want {
+		req, ok := f.Next(ctx)
+		if !ok {
+			t.Fatal("Next returned no page")
+		}
+		if req.Path != path {
+			t.Errorf("got %s, want %s", req.Path, path)
+		}
+	}
+	if f.Len() != 0 {
+		t.Errorf("Len = %d after taking everything", f.Len())
+	}
+}
+
+func TestFrontierNextStopsOnCancel(t *testing.T) {
+	f := newFrontier(func(walker.WalkerRequest) bool { return true })
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool)
+	go func() {
+		_, ok := f.Next(ctx)
+		done <- ok
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Next returned a page from an empty frontier")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next didn't return after its context was cancelled")
+	}
+}
+
+func TestFrontierPushWakesWaitingWalker(t *testing.T) {
+	f := newFrontier(func(walker.WalkerRequest) bool { return true })
+	done := make(chan walker.WalkerRequest)
+	go func() {
+		req, _ := f.Next(context.Background())
+		done <- req
+	}()
+	time.Sleep(20 * time.Millisecond)
+	f.Push(walker.WalkerRequest{Path: "https://site.example/"})
+
+	select {
+	case req := <-done:
+		if req.Path != "https://site.example/" {
+			t.Errorf("got %s", req.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Push didn't wake the waiting walker")
+	}
+}
@@ -0,0 +1,150 @@
+package workers
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/sirprodigle/linkpatrol/internal/walker"
+)
+
+// hostScheduler holds links waiting to be tested in one FIFO queue per host.
+// Testers are handed work round-robin across hosts, and a host never has more
+// than maxPerHost requests in flight, so a slow host only ties up its own share
+// of the testers.
+type hostScheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queues     map[string][]walker.WalkerRequest
+	inFlight   map[string]int
+	ring       []string // hosts with queued work, in round-robin order
+	next       int
+	maxPerHost int
+	size       int
+	closed     bool
+}
+
+func newHostScheduler(maxPerHost int) *hostScheduler {
+	if maxPerHost <= 0 {
+		maxPerHost = 1
+	}
+	s := &hostScheduler{
+		queues:     make(map[string][]walker.WalkerRequest, 100),
+		inFlight:   make(map[string]int, 100),
+		maxPerHost: maxPerHost,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Push queues a request behind the other requests for the same host. It never blocks.
+func (s *hostScheduler) Push(req walker.WalkerRequest) {
+	host := requestHost(req)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, queued := s.queues[host]; !queued {
+		s.ring = append(s.ring, host)
+	}
+	s.queues[host] = append(s.queues[host], req)
+	s.size++
+	s.cond.Signal()
+}
+
+// Next blocks until a request is available on a host that is below its
+// in-flight cap. The returned host must be passed to Done once the request has
+// been tested. ok is false once the scheduler is closed or ctx is cancelled.
+func (s *hostScheduler) Next(ctx context.Context) (req walker.WalkerRequest, host string, ok bool) {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.closed || ctx.Err() != nil {
+			return walker.WalkerRequest{}, "", false
+		}
+		if req, host, ok := s.take(); ok {
+			return req, host, true
+		}
+		s.cond.Wait()
+	}
+}
+
+// take pops the next request in round-robin order, skipping hosts at their cap.
+// The caller must hold s.mu.
+func (s *hostScheduler) take() (walker.WalkerRequest, string, bool) {
+	for i := 0; i < len(s.ring); i++ {
+		idx := (s.next + i) % len(s.ring)
+		host := s.ring[idx]
+		if s.inFlight[host] >= s.maxPerHost {
+			continue
+		}
+
+		queue := s.queues[host]
+		req := queue[0]
+		queue[0] = walker.WalkerRequest{}
+		if len(queue) == 1 {
+			delete(s.queues, host)
+			s.ring = append(s.ring[:idx], s.ring[idx+1:]...)
+			s.next = idx
+		} else {
+			s.queues[host] = queue[1:]
+			s.next = idx + 1
+		}
+		if len(s.ring) > 0 {
+			s.next %= len(s.ring)
+		} else {
+			s.next = 0
+		}
+
+		s.inFlight[host]++
+		s.size--
+		return req, host, true
+	}
+	return walker.WalkerRequest{}, "", false
+}
+
+// Done releases the in-flight slot taken by Next for host.
+func (s *hostScheduler) Done(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight[host]--; s.inFlight[host] <= 0 {
+		delete(s.inFlight, host)
+	}
+	s.cond.Broadcast()
+}
+
+// Len returns the number of queued requests that have not been handed out yet.
+func (s *hostScheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Close wakes every waiting tester and makes further calls to Next return false.
+func (s *hostScheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// requestHost returns the host a request will be sent to, falling back to the
+// host of the referring page for relative links and fragments.
+func requestHost(req walker.WalkerRequest) string {
+	if u, err := url.Parse(req.Path); err == nil && u.Host != "" {
+		return u.Host
+	}
+	if u, err := url.Parse(req.BasePath); err == nil {
+		return u.Host
+	}
+	return ""
+}
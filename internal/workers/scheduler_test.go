@@ -0,0 +1,150 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/walker"
+)
+
+func link(rawURL string) walker.WalkerRequest {
+	return walker.WalkerRequest{Path: rawURL}
+}
+
+// mustNext takes the next request without blocking the test forever
+func mustNext(t *testing.T, s *hostScheduler) (walker.WalkerRequest, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, host, ok := s.Next(ctx)
+	if !ok {
+		t.Fatal("Next returned no request")
+	}
+	return req, host
+}
+
+func TestHostSchedulerFIFOPerHost(t *testing.T) {
+	s := newHostScheduler(10)
+	for _, path := range []string{"/1", "/2", "/3"} {
+		s.Push(link("https://a.example" + path))
+	}
+
+	for _, want := range []string{"/1", "/2", "/3"} {
+		req, host := mustNext(t, s)
+		if req.Path != "https://a.example"+want {
+			t.Errorf("got %s, want %s", req.Path, want)
+		}
+		if host != "a.example" {
+			t.Errorf("host = %q, want a.example", host)
+		}
+	}
+	if s.Len() != 0 {
+		t.Errorf("Len = %d after taking everything", s.Len())
+	}
+}
+
+func TestHostSchedulerRoundRobin(t *testing.T) {
+	s := newHostScheduler(10)
+	s.Push(link("https://a.example/1"))
+	s.Push(link("https://a.example/2"))
+	s.Push(link("https://a.example/3"))
+	s.Push(link("https://b.example/1"))
+	s.Push(link("https://c.example/1"))
+	s.Push(link("https://c.example/2"))
+
+	var got []string
+	for range 6 {
+		req, _ := mustNext(t, s)
+		got = append(got, req.Path)
+	}
+	want := []string{
+		"https://a.example/1", "https://b.example/1", "https://c.example/1",
+		"https://a.example/2", "https://c.example/2",
+		"https://a.example/3",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHostSchedulerCapsInFlightPerHost(t *testing.T) {
+	s := newHostScheduler(2)
+	for _, path := range []string{"/1", "/2", "/3"} {
+		s.Push(link("https://slow.example" + path))
+	}
+	s.Push(link("https://fast.example/1"))
+
+	want := []string{"slow.example", "fast.example", "slow.example"}
+	for _, wantHost := range want {
+		if _, host := mustNext(t, s); host != wantHost {
+			t.Fatalf("got a link for %s, want %s", host, wantHost)
+		}
+	}
+
+	// slow.example has 2 in flight and fast.example has nothing left
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if req, _, ok := s.Next(ctx); ok {
+		t.Fatalf("got %s while slow.example is at its cap", req.Path)
+	}
+
+	s.Done("slow.example")
+	req, _ := mustNext(t, s)
+	if req.Path != "https://slow.example/3" {
+		t.Errorf("after Done got %s, want the last slow.example link", req.Path)
+	}
+}
+
+func TestHostSchedulerRelativeLinksUseReferrerHost(t *testing.T) {
+	s := newHostScheduler(1)
+	s.Push(walker.WalkerRequest{Path: "/about", BasePath: "https://site.example/"})
+	if _, host := mustNext(t, s); host != "site.example" {
+		t.Errorf("host = %q, want site.example", host)
+	}
+}
+
+func TestHostSchedulerNextStopsOnCancel(t *testing.T) {
+	s := newHostScheduler(1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool)
+	go func() {
+		_, _, ok := s.Next(ctx)
+		done <- ok
+	}()
+	// Let Next start waiting, so only the AfterFunc can wake it
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Next returned a request from an empty scheduler")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next didn't return after its context was cancelled")
+	}
+}
+
+func TestHostSchedulerNextStopsOnClose(t *testing.T) {
+	s := newHostScheduler(1)
+	done := make(chan bool)
+	go func() {
+		_, _, ok := s.Next(context.Background())
+		done <- ok
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Next returned a request after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next didn't return after Close")
+	}
+}
@@ -22,9 +22,9 @@ func (wp *WorkerPool) GetStats() WorkerPoolStats {
 		ActiveTesters:   wp.activeTesters.Load(),
 		DomainCount:     int32(wp.GetDomainCount()),
 		TotalGoroutines: int32(runtime.NumGoroutine()),
-		ResultsObtained: int32(len(wp.resultsCache.ResultsData)),
-		ResultsToTest:   int32(len(wp.toTestChan)),
-		PathsToWalk:     int32(len(wp.toWalkChan)),
+		ResultsObtained: int32(wp.resultsCache.Count()),
+		ResultsToTest:   int32(wp.testQueue.Len()),
+		PathsToWalk:     int32(wp.walkQueue.Len()),
 	}
 }
 
@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// workTracker counts queued and in-flight work items. Every enqueue calls Add
+// before the item becomes visible to a worker, and the worker calls Done once
+// it has finished, including any follow-up work it enqueued. The count can
+// therefore only reach zero when the whole crawl is finished.
+type workTracker struct {
+	outstanding atomic.Int64
+	done        chan struct{}
+	doneOnce    sync.Once
+}
+
+func newWorkTracker() *workTracker {
+	return &workTracker{done: make(chan struct{})}
+}
+
+// Add records n newly enqueued work items
+func (t *workTracker) Add(n int) {
+	t.outstanding.Add(int64(n))
+}
+
+// Done records a finished work item, closing the done channel when nothing is left
+func (t *workTracker) Done() {
+	if t.outstanding.Add(-1) == 0 {
+		t.doneOnce.Do(func() { close(t.done) })
+	}
+}
+
+// Outstanding returns the number of work items that haven't finished yet
+func (t *workTracker) Outstanding() int64 {
+	return t.outstanding.Load()
+}
+
+// Wait returns a channel that is closed once all work has finished
+func (t *workTracker) Wait() <-chan struct{} {
+	return t.done
+}
@@ -0,0 +1,78 @@
+package workers
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+)
+
+func TestWorkTrackerClosesOnceAllWorkIsDone(t *testing.T) {
+	tracker := newWorkTracker()
+	tracker.Add(2)
+	tracker.Done()
+	select {
+	case <-tracker.Wait():
+		t.Fatal("closed with work outstanding")
+	default:
+	}
+	tracker.Add(1)
+	tracker.Done()
+	tracker.Done()
+	select {
+	case <-tracker.Wait():
+	default:
+		t.Fatal("not closed once all work was done")
+	}
+}
+
+// heldSends holds the debug line SendURLs logs before queueing its second seed
+// until release is closed
+type heldSends struct {
+	sends   int
+	release chan struct{}
+}
+
+func (h *heldSends) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "Sending url to walker") {
+		h.sends++
+		if h.sends == 2 {
+			select {
+			case <-h.release:
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func TestSendURLsCountsEverySeedBeforeTheFirstRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	held := &heldSends{release: make(chan struct{})}
+	log := logger.New(true, logger.WithOutput(held), logger.WithErrorOutput(io.Discard))
+	results := make(chan cache.CacheEntry, 10)
+	wp := NewWorkerPool(cache.NewResultsCache(results), 1, 1, time.Second, 0, 0, results, log, "https://site.example/", nil)
+
+	// A walker that finishes the first seed while the rest are still being sent
+	go func() {
+		if _, ok := wp.walkQueue.Next(ctx); ok {
+			wp.work.Done()
+		}
+		close(held.release)
+	}()
+	wp.SendURLs(ctx, "https://site.example/a", "https://site.example/b", "https://site.example/c")
+
+	select {
+	case <-wp.work.Wait():
+		t.Fatal("the run was over before every seed was sent")
+	default:
+	}
+	if outstanding := wp.work.Outstanding(); outstanding != 2 {
+		t.Errorf("outstanding = %d, want the 2 seeds left", outstanding)
+	}
+}
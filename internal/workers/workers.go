@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,30 +21,63 @@ import (
 type WorkerPool struct {
 	logger         *Logger
 	resultsCache   *cache.ResultsCache
-	concurrency    int
+	pages          *cache.PageCache
+	walkers        int
+	testers        int
 	rateLimitValue int
 	domainLimiters map[string]*domainLimiter
 	limiterMutex   sync.RWMutex
 	resultsChan    chan<- cache.CacheEntry
-	toTestChan     chan walker.WalkerRequest
-	toWalkChan     chan walker.WalkerRequest
+	testQueue      *hostScheduler
+	walkQueue      *frontier
 	timeout        time.Duration
 	client         *http.Client
 	baseUrl        string
 
 	activeWalkers atomic.Int32
 	activeTesters atomic.Int32
+	work          *workTracker
+
+	// walkerCount is the number of running walker goroutines
+	walkerCount atomic.Int32
+
+	// testerCount is the number of running tester goroutines, which retire
+	// themselves while it is above testerTarget
+	testerCount  atomic.Int32
+	testerTarget atomic.Int32
+	tuner        *testerTuner
+
+	// idleCtx is what testers wait for work with. wakeIdle cancels it, and
+	// replaces it, so idle testers re-check testerTarget when it drops.
+	idleMu   sync.Mutex
+	idleCtx  context.Context
+	wakeIdle context.CancelFunc
+
+	externalAnchors ExternalAnchorOptions
+	soft404         *Soft404Detector
+	logoutPatterns  []string
+	inScope         func(*url.URL) bool
+	showStats       bool
+	onRateLimitWait func(domain string, waited time.Duration)
+
+	// requestCtx outlives the run context so in-flight requests can drain after an interrupt
+	requestCtx     context.Context
+	cancelRequests context.CancelFunc
 
 	defaultRateLimiter *rate.Limiter
 }
 
+// abortWait is how long drain waits for workers to exit after their requests are aborted
+const abortWait = time.Second
+
 type domainLimiter struct {
 	limiter  *rate.Limiter
 	lastUsed time.Time
 }
 
-func NewWorkerPool(cache *cache.ResultsCache, concurrency int, timeout time.Duration, rateLimit int, resultsChan chan<- cache.CacheEntry, toWalkChan chan walker.WalkerRequest, toTestChan chan walker.WalkerRequest, log *Logger, baseUrl string) *WorkerPool {
-	client := &http.Client{
+// NewClient returns the HTTP client walkers and testers use by default
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
 			MaxIdleConns:        2000,
@@ -81,10 +115,30 @@ func NewWorkerPool(cache *cache.ResultsCache, concurrency int, timeout time.Dura
 			}).DialContext,
 		},
 	}
-	return &WorkerPool{
+}
+
+// UseSystemResolver makes a client from NewClient look names up with the
+// system resolver instead of 1.1.1.1, for networks that only allow traffic
+// through a proxy
+func UseSystemResolver(client *http.Client) {
+	client.Transport.(*http.Transport).DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+}
+
+// NewWorkerPool creates a pool whose walkers and testers share client. If
+// client is nil, one is created with NewClient.
+func NewWorkerPool(resultsCache *cache.ResultsCache, walkers int, testers int, timeout time.Duration, rateLimit int, maxPerHost int, resultsChan chan<- cache.CacheEntry, log *Logger, baseUrl string, client *http.Client) *WorkerPool {
+	if client == nil {
+		client = NewClient(timeout)
+	}
+	wp := &WorkerPool{
 		logger:             log,
-		resultsCache:       cache,
-		concurrency:        concurrency,
+		resultsCache:       resultsCache,
+		pages:              cache.NewPageCache(),
+		walkers:            walkers,
+		testers:            testers,
 		timeout:            timeout,
 		rateLimitValue:     rateLimit,
 		domainLimiters:     make(map[string]*domainLimiter, 100),
@@ -92,90 +146,311 @@ func NewWorkerPool(cache *cache.ResultsCache, concurrency int, timeout time.Dura
 		client:             client,
 		baseUrl:            baseUrl,
 		defaultRateLimiter: rate.NewLimiter(rate.Inf, 0),
-		toWalkChan:         toWalkChan,
-		toTestChan:         toTestChan,
+		testQueue:          newHostScheduler(maxPerHost),
+		work:               newWorkTracker(),
 	}
+	wp.walkQueue = newFrontier(wp.isInternal)
+	return wp
 }
 
+// Start launches the walkers and testers. Cancelling ctx stops them picking up
+// new work, while requests already in flight keep running until Abort is called.
 func (wp *WorkerPool) Start(ctx context.Context) {
+	wp.requestCtx, wp.cancelRequests = context.WithCancel(context.WithoutCancel(ctx))
 	wp.startWalkers(ctx)
 	wp.startTesters(ctx)
+	if wp.tuner != nil {
+		go wp.autoTuneTesters(ctx)
+	}
+}
+
+// EnableAutoTune lets the pool grow the testers up to maxTesters while the
+// test backlog builds up, and shrink back to the configured count when it
+// clears. It must be called before Start.
+func (wp *WorkerPool) EnableAutoTune(maxTesters int) {
+	wp.tuner = newTesterTuner(wp.testers, maxTesters)
+}
+
+// EnableExternalAnchors makes testers download pages on other sites, up to
+// maxPageSize bytes, to check that linked fragments exist on them. Hosts in
+// ignoreHosts are skipped. It must be called before Start.
+func (wp *WorkerPool) EnableExternalAnchors(maxPageSize int64, ignoreHosts []string) {
+	wp.externalAnchors = ExternalAnchorOptions{
+		Enabled:     true,
+		MaxPageSize: maxPageSize,
+		IgnoreHosts: ignoreHosts,
+	}
+}
+
+// EnableSoft404 makes walkers and testers flag pages that return 2xx but look like the
+// host's not-found page or contain one of phrases. It must be called before Start.
+func (wp *WorkerPool) EnableSoft404(phrases []string) {
+	wp.soft404 = NewSoft404Detector(wp.client, wp, phrases)
+}
+
+// SkipLogoutLinks stops walkers following or testing links whose path
+// contains one of patterns, so a logged-in session survives the crawl. It
+// must be called before Start.
+func (wp *WorkerPool) SkipLogoutLinks(patterns []string) {
+	wp.logoutPatterns = patterns
+}
+
+// SetScope decides which pages the walkers crawl, in place of staying on the
+// base URL's host. It must be called before Start.
+func (wp *WorkerPool) SetScope(inScope func(*url.URL) bool) {
+	wp.inScope = inScope
+}
+
+// OnRateLimitWait sets fn to be called whenever a request had to wait for its
+// host's rate limit. It must be called before Start.
+func (wp *WorkerPool) OnRateLimitWait(fn func(domain string, waited time.Duration)) {
+	wp.onRateLimitWait = fn
+}
+
+// EnableStats redraws live progress stats while WaitAndClose waits, unless
+// logging is verbose. It must be called before Start.
+func (wp *WorkerPool) EnableStats() {
+	wp.showStats = true
 }
 
 func (wp *WorkerPool) startWalkers(ctx context.Context) {
-	for i := 0; i < wp.concurrency; i++ {
-		walker := walker.NewWalker(wp.client, wp.resultsCache, wp.toWalkChan, wp.toTestChan, &wp.activeWalkers, wp.logger, wp.baseUrl, wp, wp.resultsChan)
+	// A nil detector must stay a nil interface for the walkers to skip the check
+	var soft404 walker.Soft404Checker
+	if wp.soft404 != nil {
+		soft404 = wp.soft404
+	}
+	for i := 0; i < wp.walkers; i++ {
+		walker := walker.NewWalker(wp.client, wp.resultsCache, wp.pages, wp, wp, &wp.activeWalkers, wp.logger, wp.baseUrl, wp, wp.resultsChan, wp.logoutPatterns, wp.inScope, soft404)
+		wp.walkerCount.Add(1)
 		go func() {
+			defer wp.walkerCount.Add(-1)
 			for {
-				select {
-				case <-ctx.Done():
+				toTest, ok := wp.walkQueue.Next(ctx)
+				if !ok {
 					return
-				case toTest, ok := <-wp.toWalkChan:
-					if !ok {
-						return
-					}
-					walker.Walk(ctx, toTest)
 				}
+				walker.Walk(wp.requestCtx, toTest)
+				wp.work.Done()
 			}
 		}()
 	}
 }
 
 func (wp *WorkerPool) startTesters(ctx context.Context) {
+	wp.testerTarget.Store(int32(wp.testers))
+	wp.idleCtx, wp.wakeIdle = context.WithCancel(ctx)
+	for i := 0; i < wp.testers; i++ {
+		wp.spawnTester(ctx)
+	}
+}
 
-	for i := 0; i < wp.concurrency; i++ {
-		go func(workerID int) {
-			tester := NewTester(wp.resultsCache, wp.toTestChan, wp, wp.logger.IsVerbose(), &wp.activeTesters, wp.client, wp.resultsChan)
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case toTest, ok := <-wp.toTestChan:
-					if !ok {
-						return
-					}
-					tester.Test(ctx, toTest)
+func (wp *WorkerPool) spawnTester(ctx context.Context) {
+	wp.testerCount.Add(1)
+	go func() {
+		tester := NewTester(wp.resultsCache, wp.pages, wp, wp.logger.IsVerbose(), &wp.activeTesters, wp.client, wp.resultsChan, wp.baseUrl, wp.externalAnchors, wp.soft404)
+		for {
+			// Taken before checking the target, so a drop in between still wakes this tester
+			waiting := wp.idleContext()
+			if wp.retireTester() {
+				return
+			}
+			toTest, host, ok := wp.testQueue.Next(waiting)
+			if !ok {
+				// Woken up to re-check the target, rather than stopped
+				if ctx.Err() == nil && waiting.Err() != nil {
+					continue
 				}
+				wp.testerCount.Add(-1)
+				return
 			}
-		}(i)
-	}
+			start := time.Now()
+			tester.Test(wp.requestCtx, toTest)
+			if wp.tuner != nil {
+				wp.tuner.Observe(time.Since(start))
+			}
+			wp.testQueue.Done(host)
+			wp.work.Done()
+		}
+	}()
+}
+
+// idleContext returns the context testers currently wait for work with
+func (wp *WorkerPool) idleContext() context.Context {
+	wp.idleMu.Lock()
+	defer wp.idleMu.Unlock()
+	return wp.idleCtx
 }
 
-func (wp *WorkerPool) IsIdle() bool {
-	walkers := wp.activeWalkers.Load()
-	testers := wp.activeTesters.Load()
-	queueEmpty := len(wp.toTestChan) == 0 && len(wp.toWalkChan) == 0 && len(wp.resultsChan) == 0
-	return walkers == 0 && testers == 0 && queueEmpty
+// wakeIdleTesters makes the testers waiting for work re-check the target, so
+// the pool shrinks without waiting for jobs that may never come
+func (wp *WorkerPool) wakeIdleTesters(ctx context.Context) {
+	wp.idleMu.Lock()
+	defer wp.idleMu.Unlock()
+	wp.wakeIdle()
+	wp.idleCtx, wp.wakeIdle = context.WithCancel(ctx)
 }
 
-func (wp *WorkerPool) WaitAndClose() {
+// retireTester claims a retirement slot when more testers are running than
+// the auto-tuner wants. It returns true if the caller should exit.
+func (wp *WorkerPool) retireTester() bool {
 	for {
-		if !wp.logger.IsVerbose() {
-			wp.logger.PrettyPrintStats(wp)
+		count := wp.testerCount.Load()
+		if count <= wp.testerTarget.Load() {
+			return false
+		}
+		if wp.testerCount.CompareAndSwap(count, count-1) {
+			return true
+		}
+	}
+}
+
+// WaitAndClose blocks until all queued work is finished and then closes the
+// queues and the results channel. If ctx is cancelled first, in-flight
+// requests get up to grace to finish before they are aborted, and false is
+// returned. Either way the results loop finishes, so ResultsCache.Wait returns.
+func (wp *WorkerPool) WaitAndClose(ctx context.Context, grace time.Duration) bool {
+	stopStats := wp.startStatsTicker()
+	defer stopStats()
+
+	// Nothing was ever enqueued, so there is nothing to wait for
+	if wp.work.Outstanding() == 0 {
+		wp.logger.Debug("No work was queued")
+	} else {
+		select {
+		case <-wp.work.Wait():
+		case <-ctx.Done():
+			wp.drain(grace)
+			return false
 		}
+	}
 
-		if wp.IsIdle() {
-			// Require 2 consecutive idle checks to close
-			time.Sleep(100 * time.Millisecond)
-			if wp.IsIdle() {
-				break
+	// Every walker and tester has returned, so nothing can still be sending
+	wp.testQueue.Close()
+	wp.walkQueue.Close()
+	close(wp.resultsChan)
+	wp.cancelRequests()
+	return true
+}
+
+// startStatsTicker redraws the live stats until the returned func is called
+func (wp *WorkerPool) startStatsTicker() func() {
+	if !wp.showStats || wp.logger.IsVerbose() {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			wp.logger.PrettyPrintStats(wp)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
 			}
 		}
-		time.Sleep(10 * time.Millisecond)
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+		// Final redraw so the stats reflect the finished run
+		wp.logger.PrettyPrintStats(wp)
 	}
+}
 
-	close(wp.toTestChan)
-	close(wp.toWalkChan)
+// drain stops handing out queued work and waits for the workers to exit,
+// aborting in-flight requests once grace has passed. When every worker has
+// exited the results channel is closed; workers that still haven't exited
+// abortWait after the abort may be part-way through sending to it, so it's
+// left open and the results loop is stopped instead.
+func (wp *WorkerPool) drain(grace time.Duration) {
+	wp.walkQueue.Close()
+	wp.testQueue.Close()
+
+	deadline := time.After(grace)
+	aborted := wp.requestCtx.Done()
+	var abortDeadline <-chan time.Time
+	poll := time.NewTicker(10 * time.Millisecond)
+	defer poll.Stop()
+	for wp.runningCount() > 0 {
+		select {
+		case <-deadline:
+			wp.logger.Warn("Grace period expired, aborting %d in-flight requests", wp.activeCount())
+			wp.Abort()
+		case <-aborted:
+			// Aborted requests return quickly, so give their workers a moment to exit.
+			// A closed channel is always ready, so it's only waited on once.
+			aborted = nil
+			abortDeadline = time.After(abortWait)
+		case <-abortDeadline:
+			wp.logger.Warn("%d workers didn't stop after the abort, their results are dropped", wp.runningCount())
+			wp.resultsCache.Stop()
+			return
+		case <-poll.C:
+		}
+	}
 	close(wp.resultsChan)
+	wp.Abort()
+}
+
+// Abort cancels every in-flight request
+func (wp *WorkerPool) Abort() {
+	wp.cancelRequests()
+}
+
+// runningCount is the number of walker and tester goroutines that haven't exited
+func (wp *WorkerPool) runningCount() int32 {
+	return wp.walkerCount.Load() + wp.testerCount.Load()
+}
+
+func (wp *WorkerPool) activeCount() int32 {
+	return wp.activeWalkers.Load() + wp.activeTesters.Load()
+}
+
+// EnqueueWalk queues a same-site page for crawling. It never blocks.
+func (wp *WorkerPool) EnqueueWalk(req walker.WalkerRequest) {
+	wp.work.Add(1)
+	wp.walkQueue.Push(req)
+}
+
+// EnqueueTest queues a link for testing behind other links on the same host
+func (wp *WorkerPool) EnqueueTest(req walker.WalkerRequest) {
+	wp.work.Add(1)
+	wp.testQueue.Push(req)
 }
 
 func (wp *WorkerPool) SendURLs(ctx context.Context, urls ...string) {
+	// Walkers are already running, so every seed is counted before the first
+	// is queued: otherwise a seed finishing early would end the run before the
+	// rest were sent
+	wp.work.Add(len(urls))
 	for _, url := range urls {
 		wp.logger.Debug("Sending url to walker: %s", url)
-		wp.toWalkChan <- walker.WalkerRequest{
+		wp.walkQueue.Push(walker.WalkerRequest{
 			Path:     url,
 			BasePath: wp.baseUrl,
-		}
+			Seed:     true,
+		})
+	}
+}
+
+// isInternal reports whether a request is for a page on the target site
+func (wp *WorkerPool) isInternal(req walker.WalkerRequest) bool {
+	base, err := url.Parse(wp.baseUrl)
+	if err != nil {
+		return false
+	}
+	return requestHost(req) == base.Host
+}
+
+// RateLimitWaited reports a request that had to wait for domain's rate limit
+func (wp *WorkerPool) RateLimitWaited(domain string, waited time.Duration) {
+	if wp.onRateLimitWait != nil {
+		wp.onRateLimitWait(domain, waited)
 	}
 }
 
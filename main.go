@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"syscall"
 
 	"github.com/sirprodigle/linkpatrol/internal/app"
 	"github.com/sirprodigle/linkpatrol/internal/config"
+	"github.com/sirprodigle/linkpatrol/internal/monitor"
+	"github.com/sirprodigle/linkpatrol/internal/server"
 	"github.com/spf13/cobra"
 )
 
+// exitInterrupted is the exit code for a run stopped by SIGINT/SIGTERM, following the 128+SIGINT shell convention
+const exitInterrupted = 130
+
 var cfg config.Config
 
 var rootCmd = &cobra.Command{
@@ -23,14 +32,40 @@ var rootCmd = &cobra.Command{
 	SilenceErrors: true,
 }
 
+var serveCmd = &cobra.Command{
+	Use:           "serve",
+	Short:         "Run link checks submitted over a REST API",
+	Long:          `Serve runs LinkPatrol as a service. Scans are submitted, polled, cancelled and fetched as JSON over HTTP, and every scan starts from the usual flags and config file.`,
+	Args:          cobra.NoArgs,
+	RunE:          serve,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var monitorCmd = &cobra.Command{
+	Use:           "monitor [target-url]",
+	Short:         "Re-run the link check on a schedule and alert when links break or recover",
+	Args:          cobra.MaximumNArgs(1),
+	RunE:          runMonitor,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
 func init() {
 	cfg = config.NewConfig()
 	cfg.InitFlags(rootCmd)
+	cfg.InitServeFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+	cfg.InitMonitorFlags(monitorCmd)
+	rootCmd.AddCommand(monitorCmd)
 }
 
 func run(cmd *cobra.Command, args []string) error {
-	cfg.LoadFromViper()
-	
+	if err := cfg.LoadFromViper(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return err
+	}
+
 	// If target URL is provided as positional argument, use it
 	if len(args) > 0 {
 		cfg.Target = args[0]
@@ -59,14 +94,64 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	application := app.New(&cfg)
-	err := application.Run(context.Background())
+	application, err := app.New(&cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	err = application.Run(context.Background())
 
 	return err
 }
 
+func serve(cmd *cobra.Command, args []string) error {
+	if err := cfg.LoadFromViper(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return err
+	}
+
+	srv, err := server.New(&cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+func runMonitor(cmd *cobra.Command, args []string) error {
+	if err := cfg.LoadFromViper(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return err
+	}
+	if len(args) > 0 {
+		cfg.Target = args[0]
+	}
+
+	mon, err := monitor.New(&cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := mon.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return err
+	}
+	return nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, app.ErrInterrupted) {
+			os.Exit(exitInterrupted)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,293 @@
+// Package linkpatrol crawls websites and checks that their links work. It is
+// the engine behind the linkpatrol command and can be used directly from
+// other tools and tests:
+//
+//	checker, err := linkpatrol.New(linkpatrol.Options{
+//		Targets: []string{"https://example.com"},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	report, err := checker.Run(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	for _, result := range report.Failures() {
+//		fmt.Println(result.URL, result.Status, result.Error)
+//	}
+package linkpatrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/logger"
+	"github.com/sirprodigle/linkpatrol/internal/profiles"
+	"github.com/sirprodigle/linkpatrol/internal/workers"
+)
+
+// ErrInterrupted is returned by Run when its context was cancelled before the
+// crawl finished, so the report is partial
+var ErrInterrupted = errors.New("link check interrupted")
+
+// Options configures a Checker. Only Targets is required; zero values pick
+// the same defaults as the command line, except RateLimit, which is unlimited
+// when zero.
+type Options struct {
+	// Targets are the pages the crawl starts from
+	Targets []string
+	// Scope reports whether a page should be crawled for more links. Links to
+	// pages outside the scope are still tested. Defaults to pages on the hosts
+	// of Targets.
+	Scope func(*url.URL) bool
+	// SkipPaths are path fragments, such as "logout", whose links are neither
+	// crawled nor tested
+	SkipPaths []string
+
+	// Client sends every request. Defaults to a client that identifies as
+	// LinkPatrol and retries as a browser when a host blocks it.
+	Client *http.Client
+
+	Walkers     int           // concurrent crawlers (default 50)
+	Testers     int           // concurrent link testers (default 50)
+	MaxTesters  int           // when above Testers, testers are added while the backlog grows
+	MaxPerHost  int           // links tested at once against a single host (default 4)
+	RateLimit   int           // requests per second per host (0 = unlimited)
+	Timeout     time.Duration // per-request timeout (default 30s)
+	GracePeriod time.Duration // time in-flight requests get to finish after ctx is cancelled (default 5s)
+
+	// CheckExternalAnchors checks that fragments of links to other sites exist
+	// on the linked page, downloading at most MaxAnchorPageSize bytes of it
+	// and skipping AnchorIgnoreHosts
+	CheckExternalAnchors bool
+	MaxAnchorPageSize    int64
+	AnchorIgnoreHosts    []string
+
+	// Soft404 flags pages that answer 2xx but look like the host's not-found
+	// page or contain one of Soft404Phrases
+	Soft404        bool
+	Soft404Phrases []string
+
+	// OnResult, if set, is called with each result as soon as it's known
+	OnResult func(Result)
+	// OnRateLimitWait, if set, is called whenever a request had to wait for
+	// its host's rate limit, with how long it waited
+	OnRateLimitWait func(host string, waited time.Duration)
+	// Suppress, if set, is asked about each failure. Failures it returns true
+	// for are reported as Ignore, with the reason it gives as their Error.
+	Suppress func(Result) (reason string, suppressed bool)
+
+	// Output receives progress logs (default: discarded). Verbose adds debug
+	// logs, and ShowStats redraws live stats when not verbose.
+	Output        io.Writer
+	Verbose       bool
+	ShowStats     bool
+	TerminalWidth int
+}
+
+// Checker crawls the targets in its options and checks every link it finds
+type Checker struct {
+	opts Options
+
+	mu   sync.Mutex
+	pool *workers.WorkerPool
+}
+
+// New validates opts and returns a Checker for them
+func New(opts Options) (*Checker, error) {
+	if len(opts.Targets) == 0 {
+		return nil, fmt.Errorf("no target URL specified")
+	}
+	for _, target := range opts.Targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("target %q is not an absolute URL", target)
+		}
+	}
+
+	if opts.Walkers <= 0 {
+		opts.Walkers = 50
+	}
+	if opts.Testers <= 0 {
+		opts.Testers = 50
+	}
+	if opts.MaxPerHost <= 0 {
+		opts.MaxPerHost = 4
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = 5 * time.Second
+	}
+	if opts.MaxAnchorPageSize <= 0 {
+		opts.MaxAnchorPageSize = 5 << 20
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+	if opts.Scope == nil && len(opts.Targets) > 1 {
+		opts.Scope = targetHostsScope(opts.Targets)
+	}
+	if opts.Client == nil {
+		client, err := defaultClient(opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		opts.Client = client
+	}
+
+	return &Checker{opts: opts}, nil
+}
+
+// Run crawls the targets and returns the results once every link has been
+// checked. If ctx is cancelled first, in-flight requests get the grace period
+// to finish and the partial report is returned with ErrInterrupted.
+func (c *Checker) Run(ctx context.Context) (*Report, error) {
+	loggerOpts := []logger.Option{
+		logger.WithOutput(c.opts.Output),
+		logger.WithErrorOutput(c.opts.Output),
+	}
+	if c.opts.TerminalWidth > 0 {
+		loggerOpts = append(loggerOpts, logger.WithTerminalWidth(c.opts.TerminalWidth))
+	}
+	log := logger.New(c.opts.Verbose, loggerOpts...)
+
+	resultsChan := make(chan cache.CacheEntry, 100)
+	results := cache.NewResultsCache(resultsChan)
+	if c.opts.Suppress != nil {
+		results.Rewrite(c.suppress)
+	}
+	if c.opts.OnResult != nil {
+		results.OnResult(func(entry cache.CacheEntry) {
+			c.opts.OnResult(newResult(entry))
+		})
+	}
+
+	pool := workers.NewWorkerPool(
+		results,
+		c.opts.Walkers,
+		c.opts.Testers,
+		c.opts.Timeout,
+		c.opts.RateLimit,
+		c.opts.MaxPerHost,
+		resultsChan,
+		log,
+		c.opts.Targets[0],
+		c.opts.Client,
+	)
+	if c.opts.MaxTesters > c.opts.Testers {
+		pool.EnableAutoTune(c.opts.MaxTesters)
+	}
+	if c.opts.CheckExternalAnchors {
+		pool.EnableExternalAnchors(c.opts.MaxAnchorPageSize, c.opts.AnchorIgnoreHosts)
+	}
+	if c.opts.Soft404 {
+		pool.EnableSoft404(c.opts.Soft404Phrases)
+	}
+	if len(c.opts.SkipPaths) > 0 {
+		pool.SkipLogoutLinks(c.opts.SkipPaths)
+	}
+	if c.opts.Scope != nil {
+		pool.SetScope(c.opts.Scope)
+	}
+	if c.opts.OnRateLimitWait != nil {
+		pool.OnRateLimitWait(c.opts.OnRateLimitWait)
+	}
+	if c.opts.ShowStats {
+		pool.EnableStats()
+	}
+
+	c.mu.Lock()
+	c.pool = pool
+	c.mu.Unlock()
+
+	log.Debug("Starting worker pool with %d crawlers and %d testers", c.opts.Walkers, c.opts.Testers)
+	pool.Start(ctx)
+	results.DoLoop()
+	pool.SendURLs(ctx, c.opts.Targets...)
+
+	completed := pool.WaitAndClose(ctx, c.opts.GracePeriod)
+	results.Wait()
+
+	entries := results.GetResults()
+	report := &Report{
+		Results: make([]Result, 0, len(entries)),
+		Partial: !completed,
+	}
+	for _, entry := range entries {
+		report.Results = append(report.Results, newResult(entry))
+	}
+	if !completed {
+		return report, ErrInterrupted
+	}
+	return report, nil
+}
+
+// Abort cancels the requests still in flight in the current run, without
+// waiting for the grace period
+func (c *Checker) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != nil {
+		c.pool.Abort()
+	}
+}
+
+// Stats returns the progress of the current run, or false if no run has started
+func (c *Checker) Stats() (Stats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool == nil {
+		return Stats{}, false
+	}
+	return newStats(c.pool.GetStats()), true
+}
+
+// suppress marks a failure as ignored if Options.Suppress says so
+func (c *Checker) suppress(entry cache.CacheEntry) cache.CacheEntry {
+	if !entry.Status.IsFailure() {
+		return entry
+	}
+	reason, suppressed := c.opts.Suppress(newResult(entry))
+	if !suppressed {
+		return entry
+	}
+	entry.Error = fmt.Sprintf("%s [was %s]", reason, entry.Status)
+	entry.Status = cache.Ignore
+	return entry
+}
+
+// defaultClient builds the client used when Options.Client is nil
+func defaultClient(timeout time.Duration) (*http.Client, error) {
+	headerProfiles, err := profiles.NewHeaderProfiles(profiles.DefaultContactURL, nil, "linkpatrol", nil, "chrome")
+	if err != nil {
+		return nil, err
+	}
+	client := workers.NewClient(timeout)
+	client.Transport = profiles.NewHeaderTransport(client.Transport, headerProfiles)
+	return client, nil
+}
+
+// targetHostsScope crawls pages on the host of any of targets
+func targetHostsScope(targets []string) func(*url.URL) bool {
+	hosts := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if u, err := url.Parse(target); err == nil {
+			hosts[u.Host] = true
+		}
+	}
+	return func(u *url.URL) bool {
+		return hosts[u.Host]
+	}
+}
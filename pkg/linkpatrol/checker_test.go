@@ -0,0 +1,202 @@
+package linkpatrol_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// resultsLoops counts the goroutines recording results, which must all exit once Run returns
+func resultsLoops() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), "(*ResultsCache).DoLoop")
+}
+
+func TestRunInterruptedReturnsPartialReport(t *testing.T) {
+	before := resultsLoops()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<a href="/hang">hang</a>`)
+	})
+	mux.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		// Interrupt the run while this request is in flight, and outlast the grace period
+		cancel()
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	checker, err := linkpatrol.New(linkpatrol.Options{
+		Targets:     []string{server.URL + "/"},
+		Client:      server.Client(),
+		GracePeriod: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var report *linkpatrol.Report
+	go func() {
+		defer close(done)
+		report, err = checker.Run(ctx)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run didn't return after the grace period")
+	}
+
+	if !errors.Is(err, linkpatrol.ErrInterrupted) {
+		t.Fatalf("err = %v, want ErrInterrupted", err)
+	}
+	if !report.Partial {
+		t.Error("report isn't marked partial")
+	}
+	found := false
+	for _, result := range report.Results {
+		if result.URL == server.URL+"/" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("start page missing from partial report: %+v", report.Results)
+	}
+
+	if loops := resultsLoops(); loops > before {
+		t.Errorf("%d results loops still running after Run returned", loops-before)
+	}
+}
+
+func TestRunFlagsCrawledSoft404(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<title>Home</title><a href="/moved">moved</a>`)
+			return
+		}
+		// Every unknown path gets the same not-found page, with a 200
+		fmt.Fprint(w, `<title>Not here</title><p>Sorry, we could not find what you were looking for today.</p><a href="/template-link">x</a>`)
+	}))
+	defer server.Close()
+
+	checker, err := linkpatrol.New(linkpatrol.Options{
+		Targets: []string{server.URL + "/"},
+		Client:  server.Client(),
+		Soft404: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := checker.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]linkpatrol.Status)
+	for _, result := range report.Results {
+		statuses[strings.TrimPrefix(result.URL, server.URL)] = result.Status
+	}
+	if statuses["/moved"] != linkpatrol.Soft404 {
+		t.Errorf("/moved = %s, want SOFT 404", statuses["/moved"])
+	}
+	// The not-found page's own links aren't the site's
+	if status, found := statuses["/template-link"]; found {
+		t.Errorf("crawled a link on a soft 404 page: %s", status)
+	}
+}
+
+func TestRunChecksExternalAnchorsWithoutRefetching(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<h2 id="install">Install</h2>`)
+	}))
+	defer other.Close()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%[1]s/docs#install">ok</a><a href="%[1]s/docs#missing">broken</a>`, other.URL)
+	}))
+	defer site.Close()
+
+	checker, err := linkpatrol.New(linkpatrol.Options{
+		Targets:              []string{site.URL + "/"},
+		Client:               site.Client(),
+		Testers:              1,
+		CheckExternalAnchors: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := checker.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]linkpatrol.Status)
+	for _, result := range report.Results {
+		statuses[strings.TrimPrefix(result.URL, other.URL)] = result.Status
+	}
+	if statuses["/docs#install"] != linkpatrol.Live {
+		t.Errorf("/docs#install = %s, want LIVE", statuses["/docs#install"])
+	}
+	if statuses["/docs#missing"] != linkpatrol.MissingAnchor {
+		t.Errorf("/docs#missing = %s, want MISSING ANCHOR", statuses["/docs#missing"])
+	}
+	// Each link is requested once, and its anchors come from that same response
+	if requests != 2 {
+		t.Errorf("the linked page was requested %d times, want 2", requests)
+	}
+}
+
+func TestRunInlineIgnoreDoesNotHideLinksOnOtherPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><!-- linkpatrol-ignore-next-line -->
+<a href="/gone">ignored here</a> <a href="/unlisted">ignored</a>
+<a href="/other">other</a></body></html>`)
+	})
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><a href="/gone">checked here</a></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	checker, err := linkpatrol.New(linkpatrol.Options{
+		Targets: []string{server.URL + "/"},
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := checker.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]linkpatrol.Status)
+	for _, result := range report.Results {
+		statuses[strings.TrimPrefix(result.URL, server.URL)] = result.Status
+	}
+	if statuses["/gone"] != linkpatrol.Dead {
+		t.Errorf("/gone = %s, want Dead from the page that doesn't ignore it", statuses["/gone"])
+	}
+	if statuses["/unlisted"] != linkpatrol.Ignore {
+		t.Errorf("/unlisted = %s, want Ignore", statuses["/unlisted"])
+	}
+}
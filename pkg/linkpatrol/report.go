@@ -0,0 +1,129 @@
+package linkpatrol
+
+import (
+	"strconv"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+	"github.com/sirprodigle/linkpatrol/internal/workers"
+)
+
+// Result is the outcome of checking one link
+type Result struct {
+	URL      string
+	Status   Status
+	Error    string
+	Referrer string // the page the link was first found on, empty for the start page
+	// Redirects lists every response on the way to the final one, including
+	// it, when the link redirected
+	Redirects []Redirect
+}
+
+// Redirect is one response in a redirect chain
+type Redirect struct {
+	URL        string
+	StatusCode int
+}
+
+// Status classifies a Result
+type Status int
+
+const (
+	Live          Status = iota
+	Timeout              // the request timed out
+	Dead                 // the link is broken
+	Bot                  // the host blocked the request, so the link couldn't be checked
+	Ignore               // the link was skipped on purpose
+	MissingAnchor        // the page loaded but has no element matching the link's fragment
+	Soft404              // the page answered 2xx but is really a "not found" page
+)
+
+var statusNames = [...]string{"Live", "Timeout", "Dead", "Bot", "Ignore", "MissingAnchor", "Soft404"}
+
+func (s Status) String() string {
+	if s < 0 || int(s) >= len(statusNames) {
+		return "Status(" + strconv.Itoa(int(s)) + ")"
+	}
+	return statusNames[s]
+}
+
+// IsFailure reports whether the status means the link is broken or timed out
+func (s Status) IsFailure() bool {
+	return s == Dead || s == Timeout || s == MissingAnchor || s == Soft404
+}
+
+// Stats is a snapshot of a run's progress
+type Stats struct {
+	ActiveWalkers   int // walkers crawling a page
+	ActiveTesters   int // testers checking a link
+	DomainCount     int // hosts seen so far
+	ResultsObtained int // links checked so far
+	ResultsToTest   int // links waiting to be checked
+	PathsToWalk     int // pages waiting to be crawled
+}
+
+// Report holds every result of a run
+type Report struct {
+	Results []Result
+	// Partial is set when the run was interrupted, so some links were never checked
+	Partial bool
+}
+
+// Failures returns the results for broken and timed out links
+func (r *Report) Failures() []Result {
+	var failures []Result
+	for _, result := range r.Results {
+		if result.Status.IsFailure() {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// HasFailures reports whether any link is broken or timed out
+func (r *Report) HasFailures() bool {
+	for _, result := range r.Results {
+		if result.Status.IsFailure() {
+			return true
+		}
+	}
+	return false
+}
+
+// FailureCount returns the number of broken links (dead, soft 404 or missing
+// their anchor) and the number of timed out links
+func (r *Report) FailureCount() (broken int, timedOut int) {
+	for _, result := range r.Results {
+		switch {
+		case result.Status == Timeout:
+			timedOut++
+		case result.Status.IsFailure():
+			broken++
+		}
+	}
+	return broken, timedOut
+}
+
+// newResult copies a result out of the engine's cache
+func newResult(entry cache.CacheEntry) Result {
+	result := Result{
+		URL:      entry.URL,
+		Status:   Status(entry.Status),
+		Error:    entry.Error,
+		Referrer: entry.Referrer,
+	}
+	for _, hop := range entry.Redirects {
+		result.Redirects = append(result.Redirects, Redirect{URL: hop.URL, StatusCode: hop.StatusCode})
+	}
+	return result
+}
+
+func newStats(stats workers.WorkerPoolStats) Stats {
+	return Stats{
+		ActiveWalkers:   int(stats.ActiveWalkers),
+		ActiveTesters:   int(stats.ActiveTesters),
+		DomainCount:     int(stats.DomainCount),
+		ResultsObtained: int(stats.ResultsObtained),
+		ResultsToTest:   int(stats.ResultsToTest),
+		PathsToWalk:     int(stats.PathsToWalk),
+	}
+}
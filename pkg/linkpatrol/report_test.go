@@ -0,0 +1,48 @@
+package linkpatrol
+
+import (
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/internal/cache"
+)
+
+func TestStatusesMatchTheEngine(t *testing.T) {
+	for status, entry := range map[Status]cache.CacheEntryStatus{
+		Live:          cache.Live,
+		Timeout:       cache.Timeout,
+		Dead:          cache.Dead,
+		Bot:           cache.Bot,
+		Ignore:        cache.Ignore,
+		MissingAnchor: cache.MissingAnchor,
+		Soft404:       cache.Soft404,
+	} {
+		if got := newResult(cache.CacheEntry{Status: entry}).Status; got != status {
+			t.Errorf("engine status %s became %s", entry, got)
+		}
+		if status.String() != entry.String() {
+			t.Errorf("%s is named %q by the engine", status, entry)
+		}
+		if status.IsFailure() != entry.IsFailure() {
+			t.Errorf("%s: IsFailure = %v, engine says %v", status, status.IsFailure(), entry.IsFailure())
+		}
+	}
+	if got := Status(42).String(); got != "Status(42)" {
+		t.Errorf("unknown status = %q", got)
+	}
+}
+
+func TestNewResultCopiesRedirects(t *testing.T) {
+	entry := cache.CacheEntry{
+		URL:       "https://example.com/new",
+		Status:    cache.Live,
+		Referrer:  "https://example.com/",
+		Redirects: []cache.Redirect{{URL: "https://example.com/old", StatusCode: 301}, {URL: "https://example.com/new", StatusCode: 200}},
+	}
+	result := newResult(entry)
+	if result.URL != entry.URL || result.Referrer != entry.Referrer || len(result.Redirects) != 2 {
+		t.Fatalf("newResult = %+v", result)
+	}
+	if result.Redirects[0] != (Redirect{URL: "https://example.com/old", StatusCode: 301}) {
+		t.Errorf("first hop = %+v", result.Redirects[0])
+	}
+}
@@ -0,0 +1,218 @@
+// Package linkpatroltest checks the links of an http.Handler from a Go test,
+// without starting a server:
+//
+//	func TestNoBrokenLinks(t *testing.T) {
+//		linkpatroltest.CheckHandler(t, myapp.NewRouter())
+//	}
+//
+// Requests to the handler are served in memory. Links to other sites are
+// skipped unless a RoundTripper is given for them with WithExternal.
+package linkpatroltest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+)
+
+// DefaultBaseURL is where the handler is served unless WithBaseURL says otherwise
+const DefaultBaseURL = "http://linkpatrol.test"
+
+type config struct {
+	baseURL    string
+	startPaths []string
+	external   http.RoundTripper
+	configure  []func(*linkpatrol.Options)
+}
+
+// Option configures CheckHandler
+type Option func(*config)
+
+// WithBaseURL serves the handler at baseURL, for apps that build absolute links to their own host
+func WithBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithStartPaths starts the crawl from paths instead of "/"
+func WithStartPaths(paths ...string) Option {
+	return func(c *config) {
+		c.startPaths = paths
+	}
+}
+
+// WithExternal sends links to other sites through rt, and reports them along
+// with the handler's own links. Pass http.DefaultTransport to check them for
+// real, or StubExternal to fake their answers.
+func WithExternal(rt http.RoundTripper) Option {
+	return func(c *config) {
+		c.external = rt
+	}
+}
+
+// WithOptions adjusts the checker's options before the crawl, e.g. to enable
+// soft 404 detection
+func WithOptions(configure func(*linkpatrol.Options)) Option {
+	return func(c *config) {
+		c.configure = append(c.configure, configure)
+	}
+}
+
+// StubExternal returns a RoundTripper for WithExternal that answers each URL
+// in statuses with its status code, and every other URL with 200. URLs match
+// over http and https alike, since failed https links are retried over http.
+func StubExternal(statuses map[string]int) http.RoundTripper {
+	byAddress := make(map[string]int, len(statuses))
+	for rawURL, status := range statuses {
+		byAddress[withoutScheme(rawURL)] = status
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		status, ok := byAddress[withoutScheme(req.URL.String())]
+		if !ok {
+			status = http.StatusOK
+		}
+		return stubResponse(req, status), nil
+	})
+}
+
+func withoutScheme(rawURL string) string {
+	if _, rest, ok := strings.Cut(rawURL, "://"); ok {
+		return rest
+	}
+	return rawURL
+}
+
+// CheckHandler crawls handler from the start paths and fails t with every
+// broken link and the page linking to it. It returns the report so tests can
+// make further assertions.
+func CheckHandler(t testing.TB, handler http.Handler, opts ...Option) *linkpatrol.Report {
+	t.Helper()
+
+	c := &config{
+		baseURL:    DefaultBaseURL,
+		startPaths: []string{"/"},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil || base.Host == "" {
+		t.Fatalf("linkpatroltest: invalid base URL %q", c.baseURL)
+	}
+
+	targets := make([]string, 0, len(c.startPaths))
+	for _, path := range c.startPaths {
+		targets = append(targets, c.baseURL+"/"+strings.TrimPrefix(path, "/"))
+	}
+
+	options := linkpatrol.Options{
+		Targets: targets,
+		Client: &http.Client{
+			Transport: &handlerTransport{host: base.Host, handler: handler, external: c.external},
+		},
+		Walkers:    4,
+		Testers:    4,
+		MaxPerHost: 4,
+	}
+	for _, configure := range c.configure {
+		configure(&options)
+	}
+
+	checker, err := linkpatrol.New(options)
+	if err != nil {
+		t.Fatalf("linkpatroltest: %v", err)
+	}
+	report, err := checker.Run(context.Background())
+	if err != nil {
+		t.Fatalf("linkpatroltest: %v", err)
+	}
+
+	// Skipped external links were never really checked, so leave them out
+	if c.external == nil {
+		kept := report.Results[:0]
+		for _, result := range report.Results {
+			if u, err := url.Parse(result.URL); err == nil && u.Host == base.Host {
+				kept = append(kept, result)
+			}
+		}
+		report.Results = kept
+	}
+
+	if failures := report.Failures(); len(failures) > 0 {
+		t.Error(describeFailures(failures))
+	}
+	return report
+}
+
+// describeFailures lists broken links in a stable order with the pages linking to them
+func describeFailures(failures []linkpatrol.Result) string {
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].URL < failures[j].URL
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "found %d broken links:", len(failures))
+	for _, failure := range failures {
+		fmt.Fprintf(&b, "\n  %s: %s", failure.URL, failure.Status)
+		if failure.Error != "" {
+			fmt.Fprintf(&b, " (%s)", failure.Error)
+		}
+		if failure.Referrer != "" {
+			fmt.Fprintf(&b, "\n    linked from %s", failure.Referrer)
+		}
+	}
+	return b.String()
+}
+
+// handlerTransport serves requests for host from handler in memory. Requests
+// for other hosts go to external, or get an empty 200 when it is nil.
+type handlerTransport struct {
+	host     string
+	handler  http.Handler
+	external http.RoundTripper
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		if t.external == nil {
+			return stubResponse(req, http.StatusOK), nil
+		}
+		return t.external.RoundTrip(req)
+	}
+
+	// Give the handler the request as a server would see it
+	serverReq := req.Clone(req.Context())
+	serverReq.RequestURI = req.URL.RequestURI()
+	serverReq.RemoteAddr = "192.0.2.1:1234"
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, serverReq)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func stubResponse(req *http.Request, status int) *http.Response {
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(status)
+	resp := rec.Result()
+	resp.Request = req
+	return resp
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
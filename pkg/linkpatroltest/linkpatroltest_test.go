@@ -0,0 +1,177 @@
+package linkpatroltest_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatrol"
+	"github.com/sirprodigle/linkpatrol/pkg/linkpatroltest"
+)
+
+// recordingT collects the failures CheckHandler reports instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Error(args ...any) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+}
+
+func (r *recordingT) Fatalf(format string, args ...any) {
+	panic(fmt.Sprintf(format, args...))
+}
+
+// site links to a working page, a missing page, and anchors that do and don't exist
+func site(external string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="/docs">docs</a>
+<a href="/docs#intro">intro</a>
+<a href="/docs#nowhere">nowhere</a>
+<a href="/gone">gone</a>
+<a href="%s">elsewhere</a>`, external)
+	})
+	mux.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<h2 id="intro">Intro</h2>`)
+	})
+	return mux
+}
+
+func statuses(report *linkpatrol.Report) map[string]linkpatrol.Status {
+	byPath := make(map[string]linkpatrol.Status, len(report.Results))
+	for _, result := range report.Results {
+		byPath[strings.TrimPrefix(result.URL, linkpatroltest.DefaultBaseURL)] = result.Status
+	}
+	return byPath
+}
+
+func TestCheckHandlerReportsBrokenLinks(t *testing.T) {
+	rt := &recordingT{TB: t}
+	report := linkpatroltest.CheckHandler(rt, site("https://elsewhere.example/"))
+
+	got := statuses(report)
+	want := map[string]linkpatrol.Status{
+		"/":             linkpatrol.Live,
+		"/docs":         linkpatrol.Live,
+		"/docs#intro":   linkpatrol.Live,
+		"/docs#nowhere": linkpatrol.MissingAnchor,
+		"/gone":         linkpatrol.Dead,
+	}
+	for path, status := range want {
+		if got[path] != status {
+			t.Errorf("%s = %s, want %s", path, got[path], status)
+		}
+	}
+	// Without WithExternal, links to other sites aren't checked, so they aren't reported
+	if len(got) != len(want) {
+		t.Errorf("report has %d results, want %d: %v", len(got), len(want), got)
+	}
+
+	if len(rt.errors) != 1 {
+		t.Fatalf("got %d failures, want one listing every broken link: %q", len(rt.errors), rt.errors)
+	}
+	message := rt.errors[0]
+	for _, wantText := range []string{
+		"found 2 broken links",
+		linkpatroltest.DefaultBaseURL + "/docs#nowhere: MissingAnchor",
+		linkpatroltest.DefaultBaseURL + "/gone: Dead",
+		"linked from " + linkpatroltest.DefaultBaseURL + "/",
+	} {
+		if !strings.Contains(message, wantText) {
+			t.Errorf("failure message doesn't mention %q:\n%s", wantText, message)
+		}
+	}
+}
+
+func TestCheckHandlerPassesWorkingSite(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p><a href="/about">about</a></p>`)
+	})
+	mux.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p><a href="/">home</a></p>`)
+	})
+
+	// A failure here fails this test, which is the point
+	report := linkpatroltest.CheckHandler(t, mux)
+	if len(report.Results) != 2 {
+		t.Errorf("got %d results, want 2: %+v", len(report.Results), report.Results)
+	}
+}
+
+func TestCheckHandlerWithStubbedExternalLinks(t *testing.T) {
+	rt := &recordingT{TB: t}
+	report := linkpatroltest.CheckHandler(rt, site("https://elsewhere.example/moved"),
+		linkpatroltest.WithExternal(linkpatroltest.StubExternal(map[string]int{
+			"https://elsewhere.example/moved": http.StatusNotFound,
+		})),
+	)
+
+	found := false
+	for _, result := range report.Results {
+		if result.URL == "https://elsewhere.example/moved" {
+			found = true
+			if result.Status != linkpatrol.Dead {
+				t.Errorf("stubbed 404 = %s, want Dead", result.Status)
+			}
+		}
+	}
+	if !found {
+		t.Error("external link missing from the report")
+	}
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "found 3 broken links") {
+		t.Errorf("failures = %q, want the external link among 3 broken links", rt.errors)
+	}
+}
+
+func TestCheckHandlerWithBaseURLAndStartPaths(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docs/", func(w http.ResponseWriter, r *http.Request) {
+		// Absolute links to the app's own host are served by the handler too
+		fmt.Fprint(w, `<a href="https://app.example/docs/next">next</a>`)
+	})
+
+	report := linkpatroltest.CheckHandler(t, mux,
+		linkpatroltest.WithBaseURL("https://app.example/"),
+		linkpatroltest.WithStartPaths("/docs/start"),
+	)
+	got := make(map[string]bool)
+	for _, result := range report.Results {
+		got[result.URL] = true
+	}
+	for _, want := range []string{"https://app.example/docs/start", "https://app.example/docs/next"} {
+		if !got[want] {
+			t.Errorf("%s missing from the report: %v", want, got)
+		}
+	}
+}
+
+func TestCheckHandlerChecksEveryStartPath(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>no links</p>`)
+	})
+	paths := make([]string, 50)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/page-%d", i)
+	}
+
+	// Pages without links finish at once, so a start page that's checked
+	// before the rest are queued must not end the run
+	for run := range 20 {
+		report := linkpatroltest.CheckHandler(t, handler, linkpatroltest.WithStartPaths(paths...))
+		got := statuses(report)
+		for _, path := range paths {
+			if _, ok := got[path]; !ok {
+				t.Fatalf("run %d: start page %s wasn't checked, only %d of %d were", run, path, len(got), len(paths))
+			}
+		}
+	}
+}